@@ -1,13 +1,29 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
 	"time"
 
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/analyze"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/approval"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/audit"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/backup"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/config"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/owners"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/remediate"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/scan"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
 )
 
 var logger = logging.SetupLogging()
@@ -17,41 +33,217 @@ func main() {
 
 	logger.Infoln("Starting k8s-deletion-inspector")
 
-	clientset, restConfig, err := k8s.ConnectToCluster(config.CFG.Kubeconfig)
+	clientset, restConfig, err := k8s.ConnectToCluster(config.CFG.Kubeconfig, float32(config.CFG.QPS), config.CFG.Burst)
 	if err != nil {
 		logger.Fatalf("Error connecting to cluster: %v", err)
 	}
 
+	backups, err := backup.NewBackend(clientset)
+	if err != nil {
+		logger.Fatalf("Error configuring backups: %v", err)
+	}
+
+	if flag.Arg(0) == "restore" {
+		runRestore(restConfig, backups, flag.Arg(1))
+		return
+	}
+
+	auditor, err := audit.NewAuditor(clientset, config.CFG.AuditFile, config.CFG.AuditEvents)
+	if err != nil {
+		logger.Fatalf("Error configuring the audit trail: %v", err)
+	}
+
+	var approvals *approval.Queue
+	if config.CFG.RequireApproval {
+		logger.Infof("Finalizer removals and force deletions require approval, which expires after %s", config.CFG.ApprovalExpiry)
+		approvals = approval.NewQueue(config.CFG.ApprovalExpiry, auditor)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		logger.Fatalf("Error creating dynamic client: %v", err)
+	}
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		logger.Fatalf("Error creating metadata client: %v", err)
+	}
+
+	registry := loadOwnerRegistry()
+	remediator := remediate.NewRemediator(clientset, dynamicClient, metadataClient, loadPolicy(), backups, auditor, approvals)
+
+	if approvals != nil {
+		// Run approved actions at once rather than at the next cleanup cycle, which may come after the approval expired.
+		approvals.OnApprove(func() { cleanupOldResources(remediator) })
+		go approvals.Serve(approval.NewAuthenticator(clientset), config.CFG.ApprovalPort, config.CFG.ApprovalTLSCertFile, config.CFG.ApprovalTLSKeyFile)
+	}
+
+	metrics.RegisterHandler("/namespace-diagnoses", analyze.NamespaceDiagnosesHandler)
+	metrics.RegisterHandler("/cluster-blockers", analyze.ClusterBlockersHandler)
+	metrics.RegisterHandler("/ownership-graph", analyze.OwnershipGraphHandler)
+	metrics.RegisterHandler("/volume-diagnoses", analyze.VolumeDiagnosesHandler)
+	metrics.RegisterHandler("/remediation-plan", remediate.PlanHandler)
+
+	switch config.CFG.Mode {
+	case "scan":
+		go runScanLoop(clientset, restConfig, dynamicClient, registry, remediator)
+	case "watch":
+		go runWatch(clientset, restConfig, dynamicClient, registry, remediator)
+	default:
+		logger.Fatalf("Unknown mode %q, expected 'scan' or 'watch'", config.CFG.Mode)
+	}
+
+	metrics.StartMetricsServer()
+}
+
+// runScanLoop runs a full scan followed by cleanup, sleeping ScanInterval hours between scans.
+// A failed scan is retried at the next interval rather than stopping the inspector.
+func runScanLoop(clientset *kubernetes.Clientset, restConfig *rest.Config, dynamicClient dynamic.Interface, registry *owners.Registry, remediator *remediate.Remediator) {
+	for {
+		success, namespaces, totalObjects, err := scan.StartScan(clientset, restConfig)
+		switch {
+		case err != nil:
+			logger.Errorf("Error running scan, skipping remediation until the next scan: %v", err)
+		case success:
+			logger.Infof("Scan completed successfully: %d namespaces, %d objects", namespaces, totalObjects)
+		default:
+			logger.Infoln("Scan did not complete successfully")
+		}
+
+		owners.Correlate(clientset, registry)
+		reportClusterBlockers(clientset, dynamicClient)
+		reportTerminatingNamespaces(clientset)
+		reportDeletionChains()
+		reportStuckVolumes(clientset)
+		if err == nil {
+			cleanupOldResources(remediator)
+		}
+
+		// Sleep between scans
+		time.Sleep(time.Duration(config.CFG.ScanInterval) * time.Hour)
+	}
+}
+
+// runWatch keeps the stuck set up to date from informers and runs cleanup every ScanInterval hours.
+func runWatch(clientset *kubernetes.Clientset, restConfig *rest.Config, dynamicClient dynamic.Interface, registry *owners.Registry, remediator *remediate.Remediator) {
+	watcher, err := watch.NewWatcher(clientset, restConfig)
+	if err != nil {
+		logger.Fatalf("Error creating watcher: %v", err)
+	}
+
 	go func() {
-		for {
-			success, namespaces, totalObjects, err := scan.StartScan(clientset, restConfig)
-			if err != nil {
-				logger.Fatalf("Error starting scan: %v", err)
-			}
-
-			if success {
-				logger.Infof("Scan completed successfully: %d namespaces, %d objects", namespaces, totalObjects)
-			} else {
-				logger.Infoln("Scan did not complete successfully")
-			}
-
-			// Perform cleanup of old resources
-			stuckObjects := metrics.GetStuckObjects()
-			for _, obj := range stuckObjects {
-				if time.Since(obj.DeleteTimestamp) > time.Duration(config.CFG.DeleteAfter)*time.Hour {
-					err := k8s.ForceDeleteOldResource(restConfig, obj.Namespace, obj.GroupVersionResource, obj.Name)
-					if err != nil {
-						logger.Errorf("Error force deleting old resource %s in namespace %s: %v", obj.Name, obj.Namespace, err)
-					} else {
-						logger.Infof("Successfully force deleted old resource %s in namespace %s", obj.Name, obj.Namespace)
-					}
-				}
-			}
-
-			// Sleep between scans
-			time.Sleep(time.Duration(config.CFG.ScanInterval) * time.Hour)
+		ticker := time.NewTicker(time.Duration(config.CFG.ScanInterval) * time.Hour)
+		defer ticker.Stop()
+		for range ticker.C {
+			owners.Correlate(clientset, registry)
+			reportClusterBlockers(clientset, dynamicClient)
+			reportTerminatingNamespaces(clientset)
+			reportDeletionChains()
+			reportStuckVolumes(clientset)
+			cleanupOldResources(remediator)
 		}
 	}()
 
-	metrics.StartMetricsServer()
+	if err := watcher.Run(make(chan struct{})); err != nil {
+		logger.Fatalf("Error running watcher: %v", err)
+	}
+}
+
+// reportClusterBlockers logs the unavailable APIServices and webhooks that can block deletion.
+func reportClusterBlockers(clientset *kubernetes.Clientset, dynamicClient dynamic.Interface) {
+	blockers, err := analyze.DetectClusterBlockers(clientset, dynamicClient)
+	if err != nil {
+		logger.Errorf("Error detecting cluster blockers: %v", err)
+		return
+	}
+	for _, blocker := range blockers {
+		logger.Warnf("%s %s blocks deletion (%s): %s", blocker.Kind, blocker.Name, blocker.Reason, blocker.Message)
+	}
+}
+
+// reportTerminatingNamespaces logs what is blocking each Terminating namespace.
+func reportTerminatingNamespaces(clientset *kubernetes.Clientset) {
+	diagnoses, err := analyze.AnalyzeNamespaces(clientset, metrics.GetStuckObjects())
+	if err != nil {
+		logger.Errorf("Error analyzing terminating namespaces: %v", err)
+		return
+	}
+	for _, diagnosis := range diagnoses {
+		logger.Warnf("Namespace %s is stuck terminating: %s", diagnosis.Namespace, strings.Join(diagnosis.Blockers, "; "))
+	}
+}
+
+// reportDeletionChains logs each chain of stuck objects waiting on one another as a single finding.
+func reportDeletionChains() {
+	for _, chain := range analyze.BuildOwnershipGraph(metrics.GetStuckObjects()).Chains {
+		logger.Warnf("Deletion chain: %s", chain.Message)
+	}
+}
+
+// reportStuckVolumes logs why each stuck PersistentVolumeClaim and PersistentVolume is still protected.
+func reportStuckVolumes(clientset *kubernetes.Clientset) {
+	diagnoses, err := analyze.AnalyzeVolumes(clientset, metrics.GetStuckObjects())
+	if err != nil {
+		logger.Errorf("Error analyzing stuck volumes: %v", err)
+		return
+	}
+	for _, diagnosis := range diagnoses {
+		logger.Warnf("%s %s is stuck deleting: %s", diagnosis.Kind, path.Join(diagnosis.Namespace, diagnosis.Name), strings.Join(diagnosis.Blockers, "; "))
+	}
+}
+
+// loadPolicy loads the remediation policy file, or the default policy of force deleting after DeleteAfter hours.
+// Label keys used by the policy's selectors and the annotations it honours are recorded on stuck objects.
+func loadPolicy() *policy.Policy {
+	deleteAfter := time.Duration(config.CFG.DeleteAfter) * time.Hour
+	config.CFG.AnnotationsOfInterest = append(config.CFG.AnnotationsOfInterest, policy.AnnotationPrefix)
+	if config.CFG.PolicyFile == "" {
+		return policy.DefaultPolicy(deleteAfter)
+	}
+
+	remediationPolicy, err := policy.LoadFile(config.CFG.PolicyFile)
+	if err != nil {
+		logger.Fatalf("Error loading remediation policy: %v", err)
+	}
+	remediationPolicy.DefaultAfter = deleteAfter
+	config.CFG.LabelsOfInterest = append(config.CFG.LabelsOfInterest, remediationPolicy.LabelKeys()...)
+	return remediationPolicy
+}
+
+// loadOwnerRegistry loads the finalizer owner registry file on top of the built-in owners.
+func loadOwnerRegistry() *owners.Registry {
+	if config.CFG.OwnerRegistryFile == "" {
+		return owners.DefaultRegistry()
+	}
+	registry, err := owners.LoadFile(config.CFG.OwnerRegistryFile)
+	if err != nil {
+		logger.Fatalf("Error loading finalizer owner registry: %v", err)
+	}
+	return registry
+}
+
+// cleanupOldResources plans and executes remediation for stuck objects according to the remediation policy.
+func cleanupOldResources(remediator *remediate.Remediator) {
+	remediator.Run()
+}
+
+// runRestore re-creates the object of a backup, or lists the available backups when no ID is given.
+func runRestore(restConfig *rest.Config, backups backup.Backend, id string) {
+	if backups == nil {
+		logger.Fatalln("Backups are disabled, nothing to restore")
+	}
+
+	if id == "" {
+		ids, err := backups.List()
+		if err != nil {
+			logger.Fatalf("Error listing backups: %v", err)
+		}
+		for _, id := range ids {
+			fmt.Println(id)
+		}
+		return
+	}
+
+	if _, err := backup.Restore(restConfig, backups, id); err != nil {
+		logger.Fatalf("Error restoring backup %s: %v", id, err)
+	}
 }
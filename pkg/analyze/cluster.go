@@ -0,0 +1,274 @@
+package analyze
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Kinds of cluster-level deletion blocker.
+const (
+	KindAPIService                     = "APIService"
+	KindValidatingWebhookConfiguration = "ValidatingWebhookConfiguration"
+	KindMutatingWebhookConfiguration   = "MutatingWebhookConfiguration"
+)
+
+// apiServicesResource is the resource of aggregated API registrations.
+var apiServicesResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+var (
+	// lastClusterBlockers is the result of the most recent detection, served by ClusterBlockersHandler.
+	lastClusterBlockers   = []ClusterBlocker{}
+	lastClusterBlockersMu sync.Mutex
+)
+
+// ClusterBlocker is a cluster-wide condition that can prevent objects and namespaces from being deleted:
+// an unavailable aggregated API breaks discovery for the namespace controller, and a webhook that fails
+// closed rejects the DELETE and UPDATE requests that remove finalizers.
+type ClusterBlocker struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Webhook string `json:"webhook,omitempty"`
+	Service string `json:"service,omitempty"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// DetectClusterBlockers reports the APIServices that are not available and the webhooks intercepting
+// DELETE or UPDATE requests whose backing Service is missing or has no ready endpoints, and publishes them.
+func DetectClusterBlockers(clientset kubernetes.Interface, dynamicClient dynamic.Interface) ([]ClusterBlocker, error) {
+	logger.Debugln("Detecting cluster-level deletion blockers...")
+
+	blockers, err := unavailableAPIServices(dynamicClient)
+	if err != nil {
+		return nil, err
+	}
+
+	webhookBlockers, err := unavailableWebhooks(clientset)
+	if err != nil {
+		return nil, err
+	}
+	blockers = append(blockers, webhookBlockers...)
+
+	sort.SliceStable(blockers, func(i, j int) bool {
+		if blockers[i].Kind != blockers[j].Kind {
+			return blockers[i].Kind < blockers[j].Kind
+		}
+		return blockers[i].Name < blockers[j].Name
+	})
+	counts := map[string]int{KindAPIService: 0, KindValidatingWebhookConfiguration: 0, KindMutatingWebhookConfiguration: 0}
+	for _, blocker := range blockers {
+		counts[blocker.Kind]++
+	}
+	metrics.WriteClusterBlockers(counts)
+	lastClusterBlockersMu.Lock()
+	if blockers == nil {
+		lastClusterBlockers = []ClusterBlocker{}
+	} else {
+		lastClusterBlockers = blockers
+	}
+	lastClusterBlockersMu.Unlock()
+	return blockers, nil
+}
+
+// unavailableAPIServices returns a blocker for every APIService whose Available condition is not True.
+func unavailableAPIServices(dynamicClient dynamic.Interface) ([]ClusterBlocker, error) {
+	apiServices, err := dynamicClient.Resource(apiServicesResource).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing APIServices: %v", err)
+	}
+
+	var blockers []ClusterBlocker
+	for _, apiService := range apiServices.Items {
+		status, reason, message := apiServiceAvailability(&apiService)
+		if status == string(metav1.ConditionTrue) {
+			continue
+		}
+		blocker := ClusterBlocker{
+			Kind:    KindAPIService,
+			Name:    apiService.GetName(),
+			Reason:  reason,
+			Message: fmt.Sprintf("The aggregated API is not available, so discovery fails and namespace deletion cannot enumerate its resources: %s", message),
+		}
+		namespace, _, _ := unstructured.NestedString(apiService.Object, "spec", "service", "namespace")
+		name, _, _ := unstructured.NestedString(apiService.Object, "spec", "service", "name")
+		if name != "" {
+			blocker.Service = namespace + "/" + name
+		}
+		if blocker.Reason == "" {
+			blocker.Reason = "Unavailable"
+		}
+		blockers = append(blockers, blocker)
+	}
+	return blockers, nil
+}
+
+// apiServiceAvailability returns the status, reason and message of an APIService's Available condition.
+// An APIService without the condition has not been checked by the aggregator yet and is reported as Unknown.
+func apiServiceAvailability(apiService *unstructured.Unstructured) (string, string, string) {
+	conditions, _, _ := unstructured.NestedSlice(apiService.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != "Available" {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		reason, _ := condition["reason"].(string)
+		message, _ := condition["message"].(string)
+		return status, reason, message
+	}
+	return string(metav1.ConditionUnknown), "NoAvailableCondition", "the APIService has no Available condition"
+}
+
+// webhook is the part of a validating or mutating webhook relevant to whether it can block deletion.
+type webhook struct {
+	name          string
+	service       *admissionregistrationv1.ServiceReference
+	failurePolicy *admissionregistrationv1.FailurePolicyType
+	rules         []admissionregistrationv1.RuleWithOperations
+}
+
+// unavailableWebhooks returns a blocker for every webhook that fails closed on DELETE or UPDATE requests and
+// whose Service is missing or has no ready endpoints.
+func unavailableWebhooks(clientset kubernetes.Interface) ([]ClusterBlocker, error) {
+	ctx := context.Background()
+	services := make(map[string]serviceHealth)
+	var blockers []ClusterBlocker
+
+	validating, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing ValidatingWebhookConfigurations: %v", err)
+	}
+	for _, configuration := range validating.Items {
+		for _, w := range configuration.Webhooks {
+			blocker := checkWebhook(clientset, services, webhook{name: w.Name, service: w.ClientConfig.Service, failurePolicy: w.FailurePolicy, rules: w.Rules})
+			if blocker != nil {
+				blocker.Kind = KindValidatingWebhookConfiguration
+				blocker.Name = configuration.Name
+				blockers = append(blockers, *blocker)
+			}
+		}
+	}
+
+	mutating, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing MutatingWebhookConfigurations: %v", err)
+	}
+	for _, configuration := range mutating.Items {
+		for _, w := range configuration.Webhooks {
+			blocker := checkWebhook(clientset, services, webhook{name: w.Name, service: w.ClientConfig.Service, failurePolicy: w.FailurePolicy, rules: w.Rules})
+			if blocker != nil {
+				blocker.Kind = KindMutatingWebhookConfiguration
+				blocker.Name = configuration.Name
+				blockers = append(blockers, *blocker)
+			}
+		}
+	}
+	return blockers, nil
+}
+
+// checkWebhook returns a blocker when the webhook can reject deletions and its Service cannot serve it.
+// Webhooks called by URL cannot be checked and are ignored. services caches the health of each Service.
+func checkWebhook(clientset kubernetes.Interface, services map[string]serviceHealth, w webhook) *ClusterBlocker {
+	if w.service == nil || !failsClosed(w.failurePolicy) || !interceptsDeletion(w.rules) {
+		return nil
+	}
+
+	key := w.service.Namespace + "/" + w.service.Name
+	health, ok := services[key]
+	if !ok {
+		health = checkService(clientset, w.service.Namespace, w.service.Name)
+		services[key] = health
+	}
+	if health.reason == "" {
+		return nil
+	}
+	return &ClusterBlocker{
+		Webhook: w.name,
+		Service: key,
+		Reason:  health.reason,
+		Message: fmt.Sprintf("Webhook %s fails closed on DELETE or UPDATE requests, so finalizers cannot be removed: %s", w.name, health.message),
+	}
+}
+
+// failsClosed reports whether a webhook rejects requests when it cannot be called. The default is Fail.
+func failsClosed(failurePolicy *admissionregistrationv1.FailurePolicyType) bool {
+	return failurePolicy == nil || *failurePolicy == admissionregistrationv1.Fail
+}
+
+// interceptsDeletion reports whether any rule of a webhook matches DELETE or UPDATE requests, which are
+// used to delete objects and to remove their finalizers.
+func interceptsDeletion(rules []admissionregistrationv1.RuleWithOperations) bool {
+	for _, rule := range rules {
+		for _, operation := range rule.Operations {
+			switch operation {
+			case admissionregistrationv1.OperationAll, admissionregistrationv1.Delete, admissionregistrationv1.Update:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serviceHealth records why a webhook's Service cannot serve requests. An empty reason means it can.
+type serviceHealth struct {
+	reason  string
+	message string
+}
+
+// checkService checks that a Service exists and has at least one ready endpoint.
+func checkService(clientset kubernetes.Interface, namespace, name string) serviceHealth {
+	ctx := context.Background()
+	if _, err := clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			return serviceHealth{reason: "ServiceNotFound", message: fmt.Sprintf("service %s/%s does not exist", namespace, name)}
+		}
+		logger.Errorf("Error fetching service %s/%s: %v", namespace, name, err)
+		return serviceHealth{}
+	}
+
+	slices, err := clientset.DiscoveryV1().EndpointSlices(namespace).List(ctx, metav1.ListOptions{LabelSelector: discoveryv1.LabelServiceName + "=" + name})
+	if err != nil {
+		logger.Errorf("Error listing endpoints of service %s/%s: %v", namespace, name, err)
+		return serviceHealth{}
+	}
+	for _, slice := range slices.Items {
+		for _, endpoint := range slice.Endpoints {
+			if endpoint.Conditions.Ready == nil || *endpoint.Conditions.Ready {
+				return serviceHealth{}
+			}
+		}
+	}
+	return serviceHealth{reason: "NoEndpoints", message: fmt.Sprintf("service %s/%s has no ready endpoints", namespace, name)}
+}
+
+// GetClusterBlockers returns the blockers found by the most recent detection.
+func GetClusterBlockers() []ClusterBlocker {
+	lastClusterBlockersMu.Lock()
+	defer lastClusterBlockersMu.Unlock()
+	return lastClusterBlockers
+}
+
+// ClusterBlockersHandler returns the cluster-level deletion blockers found by the most recent scan as JSON.
+// It does not query the cluster, so requests cannot add load on the API server.
+func ClusterBlockersHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for cluster blockers")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GetClusterBlockers()); err != nil {
+		logger.Errorf("Failed to encode cluster blockers: %v", err)
+		http.Error(w, "Failed to encode cluster blockers", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,117 @@
+package analyze
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDetectClusterBlockers(t *testing.T) {
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{apiServicesResource: "APIServiceList"},
+		newAPIService("v1beta1.metrics.k8s.io", "False", "MissingEndpoints"),
+		newAPIService("v1.apps", "True", "Local"),
+	)
+
+	ignore := admissionregistrationv1.Ignore
+	ready := true
+	clientset := kubernetesfake.NewSimpleClientset(
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "policy", Name: "dead"}},
+		&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "policy", Name: "alive"}},
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "policy", Name: "alive-abc", Labels: map[string]string{discoveryv1.LabelServiceName: "alive"}},
+			Endpoints:  []discoveryv1.Endpoint{{Addresses: []string{"10.0.0.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}}},
+		},
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "policy-engine"},
+			Webhooks: []admissionregistrationv1.ValidatingWebhook{
+				newValidatingWebhook("dead.policy.io", "dead", admissionregistrationv1.Update, nil),
+				newValidatingWebhook("alive.policy.io", "alive", admissionregistrationv1.Delete, nil),
+				// Webhooks that fail open or only intercept creation cannot block deletion.
+				newValidatingWebhook("ignored.policy.io", "dead", admissionregistrationv1.Delete, &ignore),
+				newValidatingWebhook("create.policy.io", "dead", admissionregistrationv1.Create, nil),
+			},
+		},
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "injector"},
+			Webhooks: []admissionregistrationv1.MutatingWebhook{{
+				Name:         "missing.injector.io",
+				ClientConfig: admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{Namespace: "injector", Name: "gone"}},
+				Rules:        []admissionregistrationv1.RuleWithOperations{{Operations: []admissionregistrationv1.OperationType{admissionregistrationv1.OperationAll}}},
+			}},
+		},
+	)
+
+	blockers, err := DetectClusterBlockers(clientset, dynamicClient)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	want := []ClusterBlocker{
+		{Kind: KindAPIService, Name: "v1beta1.metrics.k8s.io", Reason: "MissingEndpoints"},
+		{Kind: KindMutatingWebhookConfiguration, Name: "injector", Webhook: "missing.injector.io", Reason: "ServiceNotFound"},
+		{Kind: KindValidatingWebhookConfiguration, Name: "policy-engine", Webhook: "dead.policy.io", Reason: "NoEndpoints"},
+	}
+	if len(blockers) != len(want) {
+		t.Fatalf("Expected %d blockers, got %+v", len(want), blockers)
+	}
+	for i, blocker := range blockers {
+		if blocker.Kind != want[i].Kind || blocker.Name != want[i].Name || blocker.Webhook != want[i].Webhook || blocker.Reason != want[i].Reason {
+			t.Errorf("Expected blocker %+v, got %+v", want[i], blocker)
+		}
+	}
+
+	// The handler serves the published blockers without querying the cluster again.
+	actions := len(clientset.Actions()) + len(dynamicClient.Actions())
+	recorder := httptest.NewRecorder()
+	ClusterBlockersHandler(recorder, httptest.NewRequest(http.MethodGet, "/cluster-blockers", nil))
+	var served []ClusterBlocker
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(served) != len(want) {
+		t.Errorf("Expected the handler to serve %d blockers, got %+v", len(want), served)
+	}
+	if len(clientset.Actions())+len(dynamicClient.Actions()) != actions {
+		t.Errorf("Expected the handler not to query the cluster")
+	}
+}
+
+// newAPIService returns an APIService with the given Available condition.
+func newAPIService(name, available, reason string) *unstructured.Unstructured {
+	apiService := &unstructured.Unstructured{Object: map[string]interface{}{
+		"spec": map[string]interface{}{
+			"service": map[string]interface{}{"namespace": "kube-system", "name": "metrics-server"},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": available, "reason": reason, "message": "endpoints for service/metrics-server in \"kube-system\" have no addresses"},
+			},
+		},
+	}}
+	apiService.SetAPIVersion("apiregistration.k8s.io/v1")
+	apiService.SetKind("APIService")
+	apiService.SetName(name)
+	return apiService
+}
+
+// newValidatingWebhook returns a webhook served by a Service in the policy namespace for one operation.
+func newValidatingWebhook(name, service string, operation admissionregistrationv1.OperationType, failurePolicy *admissionregistrationv1.FailurePolicyType) admissionregistrationv1.ValidatingWebhook {
+	return admissionregistrationv1.ValidatingWebhook{
+		Name:          name,
+		ClientConfig:  admissionregistrationv1.WebhookClientConfig{Service: &admissionregistrationv1.ServiceReference{Namespace: "policy", Name: service}},
+		Rules:         []admissionregistrationv1.RuleWithOperations{{Operations: []admissionregistrationv1.OperationType{operation}}},
+		FailurePolicy: failurePolicy,
+	}
+}
@@ -0,0 +1,250 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// GraphNode is a stuck object in the ownership graph.
+type GraphNode struct {
+	UID        types.UID `json:"uid"`
+	Scope      string    `json:"scope"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Resource   string    `json:"resource"`
+	Name       string    `json:"name"`
+	Finalizers []string  `json:"finalizers,omitempty"`
+	// Foreground is set when the object carries the foregroundDeletion finalizer and waits for its dependents.
+	Foreground bool `json:"foreground"`
+	// RootCause is set when the object blocks a chain without waiting on any stuck dependent itself.
+	RootCause bool `json:"rootCause"`
+}
+
+// GraphEdge links a foreground-deleting owner to a dependent whose ownerReference sets blockOwnerDeletion, so
+// the owner cannot be deleted until the dependent is gone.
+type GraphEdge struct {
+	Owner     types.UID `json:"owner"`
+	Dependent types.UID `json:"dependent"`
+}
+
+// DeletionChain collapses the stuck objects blocked by one another into a single finding. Objects are
+// ordered from the root owner down to the root causes, which come last.
+type DeletionChain struct {
+	Root       GraphNode   `json:"root"`
+	Objects    []GraphNode `json:"objects"`
+	RootCauses []GraphNode `json:"rootCauses"`
+	Cycle      bool        `json:"cycle,omitempty"`
+	Message    string      `json:"message"`
+}
+
+// OwnershipGraph is the graph of blocking ownerReferences between stuck objects.
+type OwnershipGraph struct {
+	Nodes  []GraphNode     `json:"nodes"`
+	Edges  []GraphEdge     `json:"edges"`
+	Chains []DeletionChain `json:"chains"`
+}
+
+// BuildOwnershipGraph links the stuck objects through their ownerReferences with blockOwnerDeletion to owners
+// carrying the foregroundDeletion finalizer, and collapses every chain of objects waiting on one another into a
+// DeletionChain. Owners that are not stuck, or not deleted in the foreground, do not wait on their dependents
+// and are left out.
+func BuildOwnershipGraph(stuckObjects []metrics.StuckObject) OwnershipGraph {
+	logger.Debugln("Building the ownership graph of stuck objects...")
+
+	nodes := make(map[types.UID]*GraphNode, len(stuckObjects))
+	for _, obj := range stuckObjects {
+		nodes[obj.UID] = &GraphNode{
+			UID:        obj.UID,
+			Scope:      obj.Scope,
+			Namespace:  obj.Namespace,
+			Resource:   obj.Resource,
+			Name:       obj.Name,
+			Finalizers: obj.Finalizers,
+			Foreground: hasFinalizer(obj.Finalizers, metav1.FinalizerDeleteDependents),
+		}
+	}
+
+	graph := OwnershipGraph{Nodes: []GraphNode{}, Edges: []GraphEdge{}, Chains: []DeletionChain{}}
+	dependents := make(map[types.UID][]types.UID)
+	owned := make(map[types.UID]bool)
+	for _, obj := range stuckObjects {
+		for _, ref := range obj.OwnerReferences {
+			if ref.BlockOwnerDeletion == nil || !*ref.BlockOwnerDeletion {
+				continue
+			}
+			owner, ok := nodes[ref.UID]
+			if !ok || !owner.Foreground || ref.UID == obj.UID {
+				continue
+			}
+			graph.Edges = append(graph.Edges, GraphEdge{Owner: ref.UID, Dependent: obj.UID})
+			dependents[ref.UID] = append(dependents[ref.UID], obj.UID)
+			owned[obj.UID] = true
+		}
+	}
+	for uid := range dependents {
+		sortUIDs(dependents[uid], nodes)
+	}
+	for _, node := range nodes {
+		node.RootCause = owned[node.UID] && len(dependents[node.UID]) == 0
+	}
+
+	uids := make([]types.UID, 0, len(nodes))
+	for uid := range nodes {
+		uids = append(uids, uid)
+	}
+	sortUIDs(uids, nodes)
+
+	// Chains start at the owners nothing else waits on. Objects left unvisited afterwards depend on each
+	// other in a cycle, which the garbage collector can never resolve.
+	visited := make(map[types.UID]bool)
+	for _, uid := range uids {
+		if !owned[uid] && len(dependents[uid]) > 0 {
+			graph.Chains = append(graph.Chains, buildChain(uid, nodes, dependents, visited, false))
+		}
+	}
+	for _, uid := range uids {
+		if owned[uid] && !visited[uid] {
+			graph.Chains = append(graph.Chains, buildChain(uid, nodes, dependents, visited, true))
+		}
+	}
+
+	for _, uid := range uids {
+		graph.Nodes = append(graph.Nodes, *nodes[uid])
+	}
+	sort.SliceStable(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].Owner != graph.Edges[j].Owner {
+			return nodeLess(nodes[graph.Edges[i].Owner], nodes[graph.Edges[j].Owner])
+		}
+		return nodeLess(nodes[graph.Edges[i].Dependent], nodes[graph.Edges[j].Dependent])
+	})
+	return graph
+}
+
+// buildChain walks the dependents of root breadth-first, so the root causes at the bottom of the chain
+// come last, and marks every object reached as visited.
+func buildChain(root types.UID, nodes map[types.UID]*GraphNode, dependents map[types.UID][]types.UID, visited map[types.UID]bool, cycle bool) DeletionChain {
+	chain := DeletionChain{Root: *nodes[root], Cycle: cycle, RootCauses: []GraphNode{}}
+	queued := map[types.UID]bool{root: true}
+	queue := []types.UID{root}
+	for len(queue) > 0 {
+		uid := queue[0]
+		queue = queue[1:]
+		visited[uid] = true
+		chain.Objects = append(chain.Objects, *nodes[uid])
+		for _, dependent := range dependents[uid] {
+			if !queued[dependent] {
+				queued[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+	sort.SliceStable(chain.Objects, func(i, j int) bool { return !chain.Objects[i].RootCause && chain.Objects[j].RootCause })
+	for _, node := range chain.Objects {
+		if node.RootCause {
+			chain.RootCauses = append(chain.RootCauses, node)
+		}
+	}
+
+	if cycle {
+		chain.Message = fmt.Sprintf("%s and %d other objects block each other's deletion in a cycle of ownerReferences", nodeName(&chain.Root), len(chain.Objects)-1)
+		return chain
+	}
+	causes := make([]string, 0, len(chain.RootCauses))
+	for i := range chain.RootCauses {
+		cause := nodeName(&chain.RootCauses[i])
+		if len(chain.RootCauses[i].Finalizers) > 0 {
+			cause += fmt.Sprintf(" (finalizers: %s)", strings.Join(chain.RootCauses[i].Finalizers, ", "))
+		}
+		causes = append(causes, cause)
+	}
+	chain.Message = fmt.Sprintf("%s is waiting on %d stuck dependents; the chain is blocked by %s", nodeName(&chain.Root), len(chain.Objects)-1, strings.Join(causes, "; "))
+	return chain
+}
+
+// hasFinalizer reports whether finalizers contains the given finalizer.
+func hasFinalizer(finalizers []string, finalizer string) bool {
+	for _, f := range finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// sortUIDs sorts UIDs by the resource, namespace and name of their nodes.
+func sortUIDs(uids []types.UID, nodes map[types.UID]*GraphNode) {
+	sort.SliceStable(uids, func(i, j int) bool { return nodeLess(nodes[uids[i]], nodes[uids[j]]) })
+}
+
+// nodeLess orders nodes by resource, namespace and name.
+func nodeLess(a, b *GraphNode) bool {
+	if a.Resource != b.Resource {
+		return a.Resource < b.Resource
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// nodeName returns a node as resource/name, or resource/namespace/name for namespaced objects.
+func nodeName(node *GraphNode) string {
+	if node.Namespace == "" {
+		return node.Resource + "/" + node.Name
+	}
+	return node.Resource + "/" + node.Namespace + "/" + node.Name
+}
+
+// DOT renders the ownership graph in Graphviz DOT. Edges point from owners to their dependents; objects
+// waiting on dependents are drawn as boxes and root causes are filled red.
+func (g OwnershipGraph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph ownership {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=ellipse];\n")
+	for i := range g.Nodes {
+		node := &g.Nodes[i]
+		attributes := []string{fmt.Sprintf("label=%q", nodeName(node))}
+		if node.Foreground {
+			attributes = append(attributes, "shape=box")
+		}
+		if node.RootCause {
+			attributes = append(attributes, "style=filled", "fillcolor=red")
+		}
+		fmt.Fprintf(&b, "  %q [%s];\n", node.UID, strings.Join(attributes, ", "))
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&b, "  %q -> %q;\n", edge.Owner, edge.Dependent)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// OwnershipGraphHandler returns the ownership graph of the stuck objects as JSON, or as Graphviz DOT
+// with ?format=dot.
+func OwnershipGraphHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for the ownership graph")
+	graph := BuildOwnershipGraph(metrics.GetStuckObjects())
+
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(graph); err != nil {
+			logger.Errorf("Failed to encode ownership graph: %v", err)
+			http.Error(w, "Failed to encode ownership graph", http.StatusInternalServerError)
+		}
+	case "dot":
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		if _, err := w.Write([]byte(graph.DOT())); err != nil {
+			logger.Errorf("Failed to write ownership graph: %v", err)
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Unknown format %q, expected json or dot", format), http.StatusBadRequest)
+	}
+}
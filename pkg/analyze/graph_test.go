@@ -0,0 +1,122 @@
+package analyze
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestBuildOwnershipGraph(t *testing.T) {
+	stuckObjects := []metrics.StuckObject{
+		newGraphObject("deployments", "web", "deployment", []string{metav1.FinalizerDeleteDependents}),
+		newGraphObject("replicasets", "web-abc", "replicaset", []string{metav1.FinalizerDeleteDependents}, blockingRef("deployment")),
+		newGraphObject("pods", "web-abc-1", "pod-1", []string{"example.com/drain"}, blockingRef("replicaset")),
+		// A dependent that does not block its owner's deletion is not part of the chain.
+		newGraphObject("pods", "web-abc-2", "pod-2", []string{"example.com/drain"}, metav1.OwnerReference{UID: "replicaset"}),
+		newGraphObject("widgets", "a", "cycle-a", []string{metav1.FinalizerDeleteDependents}, blockingRef("cycle-b")),
+		newGraphObject("widgets", "b", "cycle-b", []string{metav1.FinalizerDeleteDependents}, blockingRef("cycle-a")),
+		// An owner deleted in the background does not wait on its dependents, so it forms no chain.
+		newGraphObject("statefulsets", "db", "statefulset", []string{"example.com/protect"}),
+		newGraphObject("pods", "db-0", "db-pod", []string{"example.com/drain"}, blockingRef("statefulset")),
+	}
+
+	graph := BuildOwnershipGraph(stuckObjects)
+	if len(graph.Nodes) != len(stuckObjects) {
+		t.Errorf("Expected %d nodes, got %d", len(stuckObjects), len(graph.Nodes))
+	}
+	if len(graph.Edges) != 4 {
+		t.Errorf("Expected 4 blocking edges, got %+v", graph.Edges)
+	}
+	if len(graph.Chains) != 2 {
+		t.Fatalf("Expected 2 chains, got %+v", graph.Chains)
+	}
+	for _, node := range graph.Nodes {
+		if node.UID == "db-pod" && node.RootCause {
+			t.Errorf("Expected the dependent of a background-deleting owner not to be a root cause")
+		}
+	}
+
+	chain := graph.Chains[0]
+	if chain.Root.UID != "deployment" || chain.Cycle {
+		t.Errorf("Expected the first chain to start at the deployment, got %+v", chain.Root)
+	}
+	var objects []types.UID
+	for _, node := range chain.Objects {
+		objects = append(objects, node.UID)
+	}
+	if strings.Join(uidStrings(objects), ",") != "deployment,replicaset,pod-1" {
+		t.Errorf("Expected the chain deployment,replicaset,pod-1, got %v", objects)
+	}
+	if len(chain.RootCauses) != 1 || chain.RootCauses[0].UID != "pod-1" {
+		t.Errorf("Expected pod-1 to be the root cause, got %+v", chain.RootCauses)
+	}
+	if !strings.Contains(chain.Message, "example.com/drain") {
+		t.Errorf("Expected the message to name the root cause's finalizers, got %q", chain.Message)
+	}
+
+	if !graph.Chains[1].Cycle || len(graph.Chains[1].Objects) != 2 || len(graph.Chains[1].RootCauses) != 0 {
+		t.Errorf("Expected the widgets to form a cycle, got %+v", graph.Chains[1])
+	}
+
+	dot := graph.DOT()
+	for _, want := range []string{`"deployment" -> "replicaset";`, `"pod-1" [label="pods/default/web-abc-1", style=filled, fillcolor=red];`} {
+		if !strings.Contains(dot, want) {
+			t.Errorf("Expected the DOT output to contain %s, got:\n%s", want, dot)
+		}
+	}
+}
+
+func TestOwnershipGraphHandlerFormat(t *testing.T) {
+	tests := []struct {
+		query       string
+		wantStatus  int
+		contentType string
+	}{
+		{query: "", wantStatus: http.StatusOK, contentType: "application/json"},
+		{query: "?format=dot", wantStatus: http.StatusOK, contentType: "text/vnd.graphviz"},
+		{query: "?format=svg", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		recorder := httptest.NewRecorder()
+		OwnershipGraphHandler(recorder, httptest.NewRequest(http.MethodGet, "/ownership-graph"+tt.query, nil))
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("Expected status %d for %q, got %d", tt.wantStatus, tt.query, recorder.Code)
+		}
+		if tt.contentType != "" && recorder.Header().Get("Content-Type") != tt.contentType {
+			t.Errorf("Expected content type %s for %q, got %s", tt.contentType, tt.query, recorder.Header().Get("Content-Type"))
+		}
+	}
+}
+
+// newGraphObject returns a stuck object in the default namespace.
+func newGraphObject(resource, name string, uid types.UID, finalizers []string, ownerReferences ...metav1.OwnerReference) metrics.StuckObject {
+	return metrics.StuckObject{
+		Scope:           metrics.ScopeNamespaced,
+		Namespace:       "default",
+		Resource:        resource,
+		Name:            name,
+		UID:             uid,
+		Finalizers:      finalizers,
+		OwnerReferences: ownerReferences,
+	}
+}
+
+// blockingRef returns an ownerReference to uid that blocks the owner's deletion.
+func blockingRef(uid types.UID) metav1.OwnerReference {
+	block := true
+	return metav1.OwnerReference{UID: uid, BlockOwnerDeletion: &block}
+}
+
+// uidStrings converts UIDs to strings.
+func uidStrings(uids []types.UID) []string {
+	out := make([]string, 0, len(uids))
+	for _, uid := range uids {
+		out = append(out, string(uid))
+	}
+	return out
+}
@@ -0,0 +1,170 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var logger = logging.SetupLogging()
+
+var (
+	// lastNamespaceDiagnoses is the result of the most recent namespace analysis, served by NamespaceDiagnosesHandler.
+	lastNamespaceDiagnoses   = []NamespaceDiagnosis{}
+	lastNamespaceDiagnosesMu sync.Mutex
+)
+
+// NamespaceCondition is a deletion condition reported by the namespace controller.
+type NamespaceCondition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// NamespaceDiagnosis explains what is blocking the deletion of a Terminating namespace.
+type NamespaceDiagnosis struct {
+	Namespace         string                `json:"namespace"`
+	DeletionTimestamp time.Time             `json:"deletionTimestamp"`
+	SpecFinalizers    []string              `json:"specFinalizers,omitempty"`
+	Finalizers        []string              `json:"finalizers,omitempty"`
+	Conditions        []NamespaceCondition  `json:"conditions,omitempty"`
+	StuckObjects      []metrics.StuckObject `json:"stuckObjects,omitempty"`
+	Blockers          []string              `json:"blockers"`
+}
+
+// AnalyzeNamespaces diagnoses every Terminating namespace, correlating the namespace controller's
+// conditions with the stuck objects already found in that namespace, and publishes the diagnoses.
+func AnalyzeNamespaces(clientset k8s.ClientsetInterface, stuckObjects []metrics.StuckObject) ([]NamespaceDiagnosis, error) {
+	logger.Debugln("Analyzing terminating namespaces...")
+
+	namespaces, err := k8s.GetTerminatingNamespaces(clientset)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching terminating namespaces: %v", err)
+	}
+
+	stuckByNamespace := make(map[string][]metrics.StuckObject)
+	for _, stuckObject := range stuckObjects {
+		if stuckObject.Scope == metrics.ScopeNamespaced {
+			stuckByNamespace[stuckObject.Namespace] = append(stuckByNamespace[stuckObject.Namespace], stuckObject)
+		}
+	}
+
+	diagnoses := make([]NamespaceDiagnosis, 0, len(namespaces))
+	for i := range namespaces {
+		diagnosis := diagnoseNamespace(&namespaces[i], stuckByNamespace[namespaces[i].GetName()])
+		logger.Debugf("Namespace %s is blocked by: %s", diagnosis.Namespace, strings.Join(diagnosis.Blockers, "; "))
+		diagnoses = append(diagnoses, diagnosis)
+	}
+
+	sort.Slice(diagnoses, func(i, j int) bool { return diagnoses[i].Namespace < diagnoses[j].Namespace })
+	metrics.WriteTerminatingNamespaceCount(len(diagnoses))
+	lastNamespaceDiagnosesMu.Lock()
+	lastNamespaceDiagnoses = diagnoses
+	lastNamespaceDiagnosesMu.Unlock()
+	return diagnoses, nil
+}
+
+// diagnoseNamespace builds the diagnosis for a single Terminating namespace.
+func diagnoseNamespace(namespace *corev1.Namespace, stuckObjects []metrics.StuckObject) NamespaceDiagnosis {
+	diagnosis := NamespaceDiagnosis{
+		Namespace:    namespace.GetName(),
+		Finalizers:   namespace.GetFinalizers(),
+		StuckObjects: stuckObjects,
+		Blockers:     make([]string, 0),
+	}
+	if deletionTimestamp := namespace.GetDeletionTimestamp(); deletionTimestamp != nil {
+		diagnosis.DeletionTimestamp = deletionTimestamp.Time
+	}
+	for _, finalizer := range namespace.Spec.Finalizers {
+		diagnosis.SpecFinalizers = append(diagnosis.SpecFinalizers, string(finalizer))
+	}
+
+	contentRemaining := false
+	for _, condition := range namespace.Status.Conditions {
+		diagnosis.Conditions = append(diagnosis.Conditions, NamespaceCondition{
+			Type:    string(condition.Type),
+			Status:  string(condition.Status),
+			Reason:  condition.Reason,
+			Message: condition.Message,
+		})
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+
+		switch condition.Type {
+		case corev1.NamespaceDeletionDiscoveryFailure:
+			diagnosis.Blockers = append(diagnosis.Blockers, fmt.Sprintf("API discovery failed, so the namespace controller cannot enumerate the namespace's content; check the unavailable APIServices in /cluster-blockers: %s", condition.Message))
+		case corev1.NamespaceDeletionGVParsingFailure:
+			diagnosis.Blockers = append(diagnosis.Blockers, fmt.Sprintf("The namespace controller could not parse a group version: %s", condition.Message))
+		case corev1.NamespaceDeletionContentFailure:
+			diagnosis.Blockers = append(diagnosis.Blockers, fmt.Sprintf("Deleting content failed, often because an admission webhook or aggregated API rejected the request; see /cluster-blockers: %s", condition.Message))
+		case corev1.NamespaceContentRemaining:
+			contentRemaining = true
+			diagnosis.Blockers = append(diagnosis.Blockers, fmt.Sprintf("Content is still present: %s", condition.Message))
+		case corev1.NamespaceFinalizersRemaining:
+			contentRemaining = true
+			diagnosis.Blockers = append(diagnosis.Blockers, fmt.Sprintf("Content is waiting on finalizers: %s", condition.Message))
+		}
+	}
+
+	for _, stuckObject := range stuckObjects {
+		blocker := fmt.Sprintf("%s %s has been deleting since %s", stuckObject.GroupVersionResource.GroupResource(), stuckObject.Name, stuckObject.DeleteTimestamp.Format(time.RFC3339))
+		if len(stuckObject.Finalizers) > 0 {
+			blocker += fmt.Sprintf(" waiting on finalizers %s", strings.Join(stuckObject.Finalizers, ", "))
+		}
+		diagnosis.Blockers = append(diagnosis.Blockers, blocker)
+	}
+
+	if !contentRemaining && len(stuckObjects) == 0 {
+		if hasSpecFinalizer(namespace, corev1.FinalizerKubernetes) {
+			diagnosis.Blockers = append(diagnosis.Blockers, "The namespace appears empty but the kubernetes finalizer has not been removed; check that kube-controller-manager's namespace controller is healthy")
+		}
+		if len(namespace.GetFinalizers()) > 0 {
+			diagnosis.Blockers = append(diagnosis.Blockers, fmt.Sprintf("Metadata finalizers remain on the namespace itself: %s", strings.Join(namespace.GetFinalizers(), ", ")))
+		}
+	}
+
+	if len(diagnosis.Blockers) == 0 {
+		diagnosis.Blockers = append(diagnosis.Blockers, "No blocker identified; the namespace may still be deleting its content")
+	}
+
+	return diagnosis
+}
+
+// hasSpecFinalizer reports whether the namespace still has the given spec finalizer.
+func hasSpecFinalizer(namespace *corev1.Namespace, finalizer corev1.FinalizerName) bool {
+	for _, f := range namespace.Spec.Finalizers {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// GetNamespaceDiagnoses returns the diagnoses of the most recent namespace analysis.
+func GetNamespaceDiagnoses() []NamespaceDiagnosis {
+	lastNamespaceDiagnosesMu.Lock()
+	defer lastNamespaceDiagnosesMu.Unlock()
+	return lastNamespaceDiagnoses
+}
+
+// NamespaceDiagnosesHandler returns the diagnosis of every Terminating namespace found by the most recent
+// scan as JSON. It does not query the cluster, so requests cannot add load on the API server.
+func NamespaceDiagnosesHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for namespace diagnoses")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GetNamespaceDiagnoses()); err != nil {
+		logger.Errorf("Failed to encode namespace diagnoses: %v", err)
+		http.Error(w, "Failed to encode namespace diagnoses", http.StatusInternalServerError)
+	}
+}
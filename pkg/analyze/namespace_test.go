@@ -0,0 +1,74 @@
+package analyze
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnalyzeNamespaces(t *testing.T) {
+	deletionTimestamp := metav1.NewTime(time.Now().Add(-time.Hour))
+	clientset := kubernetesfake.NewSimpleClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "active"}},
+		&corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "stuck", DeletionTimestamp: &deletionTimestamp},
+			Spec:       corev1.NamespaceSpec{Finalizers: []corev1.FinalizerName{corev1.FinalizerKubernetes}},
+			Status: corev1.NamespaceStatus{
+				Phase: corev1.NamespaceTerminating,
+				Conditions: []corev1.NamespaceCondition{{
+					Type:    corev1.NamespaceFinalizersRemaining,
+					Status:  corev1.ConditionTrue,
+					Message: "Some content in the namespace has finalizers remaining: example.com/cleanup in 1 resource instances",
+				}},
+			},
+		},
+	)
+	stuckObjects := []metrics.StuckObject{{
+		Scope:                metrics.ScopeNamespaced,
+		Namespace:            "stuck",
+		Name:                 "widget",
+		GroupVersionResource: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"},
+	}}
+
+	diagnoses, err := AnalyzeNamespaces(clientset, stuckObjects)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(diagnoses) != 1 {
+		t.Fatalf("Expected 1 diagnosis, got %d", len(diagnoses))
+	}
+	diagnosis := diagnoses[0]
+	if diagnosis.Namespace != "stuck" {
+		t.Errorf("Expected namespace 'stuck', got %s", diagnosis.Namespace)
+	}
+	if len(diagnosis.StuckObjects) != 1 {
+		t.Errorf("Expected 1 correlated stuck object, got %d", len(diagnosis.StuckObjects))
+	}
+	if !strings.Contains(strings.Join(diagnosis.Blockers, "\n"), "example.com/cleanup") {
+		t.Errorf("Expected blockers to mention the remaining finalizer, got %v", diagnosis.Blockers)
+	}
+
+	// The handler serves the published diagnoses without querying the cluster again.
+	actions := len(clientset.Actions())
+	recorder := httptest.NewRecorder()
+	NamespaceDiagnosesHandler(recorder, httptest.NewRequest(http.MethodGet, "/namespace-diagnoses", nil))
+	var served []NamespaceDiagnosis
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(served) != 1 || served[0].Namespace != "stuck" {
+		t.Errorf("Expected the handler to serve the diagnosis of namespace 'stuck', got %+v", served)
+	}
+	if len(clientset.Actions()) != actions {
+		t.Errorf("Expected the handler not to query the cluster, got %v", clientset.Actions()[actions:])
+	}
+}
@@ -0,0 +1,382 @@
+package analyze
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Finalizers protecting volumes that are still in use.
+const (
+	FinalizerPVCProtection       = "kubernetes.io/pvc-protection"
+	FinalizerPVProtection        = "kubernetes.io/pv-protection"
+	FinalizerExternalProvisioner = "external-provisioner.volume.kubernetes.io/finalizer"
+)
+
+// Kinds of volume object diagnosed.
+const (
+	KindPersistentVolumeClaim = "PersistentVolumeClaim"
+	KindPersistentVolume      = "PersistentVolume"
+)
+
+// annotationProvisionedBy names the provisioner of a dynamically provisioned PersistentVolume.
+const annotationProvisionedBy = "pv.kubernetes.io/provisioned-by"
+
+// defaultLeaseDuration is the leader election lease duration of the CSI sidecars when the lease does not set one.
+const defaultLeaseDuration = 15 * time.Second
+
+var (
+	pvcResource = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}
+	pvResource  = schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}
+
+	// invalidLeaseNameChars matches the characters the CSI sidecars replace when naming their leader election lease.
+	invalidLeaseNameChars = regexp.MustCompile("[^a-zA-Z0-9-]")
+
+	// lastVolumeDiagnoses is the result of the most recent volume analysis, served by VolumeDiagnosesHandler.
+	lastVolumeDiagnoses   = []VolumeDiagnosis{}
+	lastVolumeDiagnosesMu sync.Mutex
+)
+
+// VolumeDiagnosis explains why a PersistentVolumeClaim or PersistentVolume is held by a protection finalizer.
+type VolumeDiagnosis struct {
+	Kind              string                    `json:"kind"`
+	Namespace         string                    `json:"namespace,omitempty"`
+	Name              string                    `json:"name"`
+	DeletionTimestamp time.Time                 `json:"deletionTimestamp"`
+	Finalizers        []string                  `json:"finalizers,omitempty"`
+	Pods              []string                  `json:"pods,omitempty"`
+	Claim             string                    `json:"claim,omitempty"`
+	VolumeAttachments []string                  `json:"volumeAttachments,omitempty"`
+	Driver            *metrics.ControllerStatus `json:"driver,omitempty"`
+	Blockers          []string                  `json:"blockers"`
+}
+
+// volumeAnalyzer caches the objects listed while diagnosing volumes, so each is fetched once per analysis.
+type volumeAnalyzer struct {
+	ctx         context.Context
+	clientset   kubernetes.Interface
+	pods        map[string][]corev1.Pod
+	attachments []storagev1.VolumeAttachment
+	leases      []coordinationv1.Lease
+	drivers     map[string]metrics.ControllerStatus
+	now         time.Time
+}
+
+// AnalyzeVolumes diagnoses the stuck PersistentVolumeClaims and PersistentVolumes waiting on a protection
+// finalizer: the Pods still using a claim, the claim and VolumeAttachments still holding a volume, and the
+// health of the CSI driver that must delete a dynamically provisioned volume. The diagnoses are published
+// for VolumeDiagnosesHandler.
+func AnalyzeVolumes(clientset kubernetes.Interface, stuckObjects []metrics.StuckObject) ([]VolumeDiagnosis, error) {
+	logger.Debugln("Analyzing stuck volumes...")
+	a := &volumeAnalyzer{
+		ctx:       context.Background(),
+		clientset: clientset,
+		pods:      make(map[string][]corev1.Pod),
+		drivers:   make(map[string]metrics.ControllerStatus),
+		now:       time.Now(),
+	}
+
+	diagnoses := make([]VolumeDiagnosis, 0)
+	for _, stuckObject := range stuckObjects {
+		var diagnosis *VolumeDiagnosis
+		var err error
+		switch {
+		case stuckObject.GroupVersionResource == pvcResource && hasFinalizer(stuckObject.Finalizers, FinalizerPVCProtection):
+			diagnosis, err = a.diagnoseClaim(stuckObject.Namespace, stuckObject.Name)
+		case stuckObject.GroupVersionResource == pvResource && (hasFinalizer(stuckObject.Finalizers, FinalizerPVProtection) || hasFinalizer(stuckObject.Finalizers, FinalizerExternalProvisioner)):
+			diagnosis, err = a.diagnoseVolume(stuckObject.Name)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if diagnosis != nil {
+			diagnoses = append(diagnoses, *diagnosis)
+		}
+	}
+
+	sort.SliceStable(diagnoses, func(i, j int) bool {
+		if diagnoses[i].Kind != diagnoses[j].Kind {
+			return diagnoses[i].Kind < diagnoses[j].Kind
+		}
+		if diagnoses[i].Namespace != diagnoses[j].Namespace {
+			return diagnoses[i].Namespace < diagnoses[j].Namespace
+		}
+		return diagnoses[i].Name < diagnoses[j].Name
+	})
+	lastVolumeDiagnosesMu.Lock()
+	lastVolumeDiagnoses = diagnoses
+	lastVolumeDiagnosesMu.Unlock()
+	return diagnoses, nil
+}
+
+// diagnoseClaim finds the Pods still using a claim, which the pvc-protection controller waits for.
+// A claim that is already gone returns nil.
+func (a *volumeAnalyzer) diagnoseClaim(namespace, name string) (*VolumeDiagnosis, error) {
+	claim, err := a.clientset.CoreV1().PersistentVolumeClaims(namespace).Get(a.ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching PersistentVolumeClaim %s/%s: %v", namespace, name, err)
+	}
+	diagnosis := newVolumeDiagnosis(KindPersistentVolumeClaim, &claim.ObjectMeta)
+
+	pods, ok := a.pods[namespace]
+	if !ok {
+		podList, err := a.clientset.CoreV1().Pods(namespace).List(a.ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing pods in namespace %s: %v", namespace, err)
+		}
+		pods = podList.Items
+		a.pods[namespace] = pods
+	}
+
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed || !usesClaim(pod, name) {
+			continue
+		}
+		diagnosis.Pods = append(diagnosis.Pods, pod.Namespace+"/"+pod.Name)
+		blocker := fmt.Sprintf("Pod %s/%s still uses the claim", pod.Namespace, pod.Name)
+		if pod.Spec.NodeName != "" {
+			blocker += fmt.Sprintf(" on node %s", pod.Spec.NodeName)
+		}
+		if deletionTimestamp := pod.GetDeletionTimestamp(); deletionTimestamp != nil {
+			blocker += fmt.Sprintf(" and has itself been terminating since %s; check the kubelet and the pod's finalizers", deletionTimestamp.Format(time.RFC3339))
+		} else {
+			blocker += "; delete the pod or the workload managing it to release the claim"
+		}
+		diagnosis.Blockers = append(diagnosis.Blockers, blocker)
+	}
+
+	if len(diagnosis.Pods) == 0 {
+		diagnosis.Blockers = append(diagnosis.Blockers, "No running pod uses the claim, so the pvc-protection controller should remove the finalizer; check that kube-controller-manager is healthy")
+	}
+	return diagnosis, nil
+}
+
+// usesClaim reports whether a pod mounts a claim directly or through a generic ephemeral volume.
+func usesClaim(pod *corev1.Pod, claimName string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+		if volume.Ephemeral != nil && pod.Name+"-"+volume.Name == claimName {
+			return true
+		}
+	}
+	return false
+}
+
+// diagnoseVolume finds the claim and VolumeAttachments still holding a volume and, for dynamically
+// provisioned volumes, the health of the CSI driver responsible for deleting it. A volume that is already
+// gone returns nil.
+func (a *volumeAnalyzer) diagnoseVolume(name string) (*VolumeDiagnosis, error) {
+	volume, err := a.clientset.CoreV1().PersistentVolumes().Get(a.ctx, name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching PersistentVolume %s: %v", name, err)
+	}
+	diagnosis := newVolumeDiagnosis(KindPersistentVolume, &volume.ObjectMeta)
+
+	if ref := volume.Spec.ClaimRef; ref != nil {
+		diagnosis.Claim = ref.Namespace + "/" + ref.Name
+	}
+	if volume.Status.Phase == corev1.VolumeBound {
+		diagnosis.Blockers = append(diagnosis.Blockers, a.boundClaimBlocker(volume))
+	}
+
+	if a.attachments == nil {
+		attachmentList, err := a.clientset.StorageV1().VolumeAttachments().List(a.ctx, metav1.ListOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error listing VolumeAttachments: %v", err)
+		}
+		a.attachments = attachmentList.Items
+	}
+	for _, attachment := range a.attachments {
+		if attachment.Spec.Source.PersistentVolumeName == nil || *attachment.Spec.Source.PersistentVolumeName != name {
+			continue
+		}
+		diagnosis.VolumeAttachments = append(diagnosis.VolumeAttachments, attachment.Name)
+		blocker := fmt.Sprintf("VolumeAttachment %s still attaches the volume to node %s (attached: %t); the CSI attacher of %s must detach it", attachment.Name, attachment.Spec.NodeName, attachment.Status.Attached, attachment.Spec.Attacher)
+		if detachError := attachment.Status.DetachError; detachError != nil {
+			blocker += fmt.Sprintf(", but detaching failed: %s", detachError.Message)
+		}
+		diagnosis.Blockers = append(diagnosis.Blockers, blocker)
+	}
+
+	if hasFinalizer(volume.GetFinalizers(), FinalizerExternalProvisioner) {
+		diagnosis.Blockers = append(diagnosis.Blockers, a.provisionerBlocker(volume, diagnosis))
+	}
+
+	if len(diagnosis.Blockers) == 0 {
+		diagnosis.Blockers = append(diagnosis.Blockers, "The volume is neither bound nor attached, so the pv-protection controller should remove the finalizer; check that kube-controller-manager is healthy")
+	}
+	return diagnosis, nil
+}
+
+// boundClaimBlocker explains why a bound volume is still protected, checking whether its claim still exists.
+func (a *volumeAnalyzer) boundClaimBlocker(volume *corev1.PersistentVolume) string {
+	ref := volume.Spec.ClaimRef
+	if ref == nil {
+		return "The volume is still Bound"
+	}
+	claim, err := a.clientset.CoreV1().PersistentVolumeClaims(ref.Namespace).Get(a.ctx, ref.Name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err), err == nil && claim.UID != ref.UID && ref.UID != "":
+		return fmt.Sprintf("The volume is still Bound to claim %s/%s, which no longer exists; the PV controller should release it", ref.Namespace, ref.Name)
+	case err != nil:
+		logger.Errorf("Error fetching PersistentVolumeClaim %s/%s: %v", ref.Namespace, ref.Name, err)
+		return fmt.Sprintf("The volume is still Bound to claim %s/%s", ref.Namespace, ref.Name)
+	case claim.GetDeletionTimestamp() != nil:
+		return fmt.Sprintf("The volume is still Bound to claim %s/%s, which is itself deleting; see the claim's diagnosis", ref.Namespace, ref.Name)
+	default:
+		return fmt.Sprintf("The volume is still Bound to claim %s/%s; delete the claim to release it", ref.Namespace, ref.Name)
+	}
+}
+
+// provisionerBlocker explains why the external-provisioner has not deleted a volume, based on the health of its CSI driver.
+func (a *volumeAnalyzer) provisionerBlocker(volume *corev1.PersistentVolume, diagnosis *VolumeDiagnosis) string {
+	driver := volume.GetAnnotations()[annotationProvisionedBy]
+	if volume.Spec.CSI != nil {
+		driver = volume.Spec.CSI.Driver
+	}
+	if driver == "" {
+		return "The external-provisioner finalizer is set but the volume names no CSI driver"
+	}
+
+	status, ok := a.drivers[driver]
+	if !ok {
+		status = a.checkCSIDriver(driver)
+		a.drivers[driver] = status
+	}
+	diagnosis.Driver = &status
+
+	switch status.Status {
+	case metrics.ControllerReady:
+		return fmt.Sprintf("The external-provisioner of CSI driver %s is running and must delete the backing volume (reclaim policy %s); check its logs for errors", driver, volume.Spec.PersistentVolumeReclaimPolicy)
+	default:
+		return fmt.Sprintf("The external-provisioner of CSI driver %s cannot delete the backing volume: %s", driver, status.Message)
+	}
+}
+
+// checkCSIDriver checks that a CSI driver is installed and that its external-provisioner holds a fresh leader
+// election lease.
+func (a *volumeAnalyzer) checkCSIDriver(driver string) metrics.ControllerStatus {
+	status := metrics.ControllerStatus{Controller: driver}
+	if _, err := a.clientset.StorageV1().CSIDrivers().Get(a.ctx, driver, metav1.GetOptions{}); err != nil {
+		if errors.IsNotFound(err) {
+			status.Status = metrics.ControllerMissing
+			status.Message = fmt.Sprintf("CSIDriver %s is not installed, so nothing will remove the finalizer", driver)
+			return status
+		}
+		logger.Errorf("Error fetching CSIDriver %s: %v", driver, err)
+		status.Status = metrics.ControllerUnknown
+		status.Message = fmt.Sprintf("error fetching CSIDriver: %v", err)
+		return status
+	}
+
+	if a.leases == nil {
+		leaseList, err := a.clientset.CoordinationV1().Leases("").List(a.ctx, metav1.ListOptions{})
+		if err != nil {
+			logger.Errorf("Error listing leases: %v", err)
+			status.Status = metrics.ControllerUnknown
+			status.Message = fmt.Sprintf("error listing leases: %v", err)
+			return status
+		}
+		a.leases = leaseList.Items
+	}
+
+	name := provisionerLeaseName(driver)
+	for _, lease := range a.leases {
+		if lease.Name != name {
+			continue
+		}
+		status.Workloads = append(status.Workloads, "Lease/"+lease.Namespace+"/"+lease.Name)
+		if lease.Spec.RenewTime == nil {
+			status.Status = metrics.ControllerNotReady
+			status.Message = fmt.Sprintf("lease %s/%s has never been renewed", lease.Namespace, lease.Name)
+			return status
+		}
+		duration := defaultLeaseDuration
+		if lease.Spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+		}
+		if expiry := lease.Spec.RenewTime.Add(duration); a.now.After(expiry) {
+			status.Status = metrics.ControllerNotReady
+			status.Message = fmt.Sprintf("lease %s/%s has not been renewed since %s, so no external-provisioner is running", lease.Namespace, lease.Name, lease.Spec.RenewTime.Format(time.RFC3339))
+			return status
+		}
+		status.Status = metrics.ControllerReady
+		if lease.Spec.HolderIdentity != nil {
+			status.Message = fmt.Sprintf("held by %s", *lease.Spec.HolderIdentity)
+		}
+		return status
+	}
+
+	status.Status = metrics.ControllerUnknown
+	status.Message = fmt.Sprintf("no leader election lease named %s was found, so the external-provisioner may not be running", name)
+	return status
+}
+
+// provisionerLeaseName returns the name of the leader election lease of a driver's external-provisioner,
+// sanitized the way the CSI sidecars do.
+func provisionerLeaseName(driver string) string {
+	name := invalidLeaseNameChars.ReplaceAllString(driver, "-")
+	if strings.HasSuffix(name, "-") {
+		name += "X"
+	}
+	return name
+}
+
+// newVolumeDiagnosis returns an empty diagnosis of a volume object.
+func newVolumeDiagnosis(kind string, meta *metav1.ObjectMeta) *VolumeDiagnosis {
+	diagnosis := &VolumeDiagnosis{
+		Kind:       kind,
+		Namespace:  meta.Namespace,
+		Name:       meta.Name,
+		Finalizers: meta.Finalizers,
+		Blockers:   make([]string, 0),
+	}
+	if meta.DeletionTimestamp != nil {
+		diagnosis.DeletionTimestamp = meta.DeletionTimestamp.Time
+	}
+	return diagnosis
+}
+
+// GetVolumeDiagnoses returns the diagnoses of the most recent volume analysis.
+func GetVolumeDiagnoses() []VolumeDiagnosis {
+	lastVolumeDiagnosesMu.Lock()
+	defer lastVolumeDiagnosesMu.Unlock()
+	return lastVolumeDiagnoses
+}
+
+// VolumeDiagnosesHandler returns the diagnosis of every stuck PersistentVolumeClaim and PersistentVolume found
+// by the most recent scan as JSON. It does not query the cluster, so requests cannot add load on the API server.
+func VolumeDiagnosesHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for volume diagnoses")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GetVolumeDiagnoses()); err != nil {
+		logger.Errorf("Failed to encode volume diagnoses: %v", err)
+		http.Error(w, "Failed to encode volume diagnoses", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,167 @@
+package analyze
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestAnalyzeVolumes(t *testing.T) {
+	now := metav1.Now()
+	stale := metav1.NewMicroTime(time.Now().Add(-time.Hour))
+	leaseDuration := int32(15)
+	boundVolume := "pv-bound"
+
+	clientset := kubernetesfake.NewSimpleClientset(
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "data", DeletionTimestamp: &now, Finalizers: []string{FinalizerPVCProtection}}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "cache-scratch", DeletionTimestamp: &now, Finalizers: []string{FinalizerPVCProtection}}},
+		&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "unused", DeletionTimestamp: &now, Finalizers: []string{FinalizerPVCProtection}}},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "db-0"},
+			Spec:       corev1.PodSpec{NodeName: "node-1", Volumes: []corev1.Volume{{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "cache"},
+			Spec:       corev1.PodSpec{Volumes: []corev1.Volume{{Name: "scratch", VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}}}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+		},
+		// Terminated pods no longer protect their claims.
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "apps", Name: "job"},
+			Spec:       corev1.PodSpec{Volumes: []corev1.Volume{{Name: "unused", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "unused"}}}}},
+			Status:     corev1.PodStatus{Phase: corev1.PodSucceeded},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: boundVolume, DeletionTimestamp: &now, Finalizers: []string{FinalizerPVProtection}},
+			Spec:       corev1.PersistentVolumeSpec{ClaimRef: &corev1.ObjectReference{Namespace: "apps", Name: "gone"}},
+			Status:     corev1.PersistentVolumeStatus{Phase: corev1.VolumeBound},
+		},
+		&storagev1.VolumeAttachment{
+			ObjectMeta: metav1.ObjectMeta{Name: "csi-abc"},
+			Spec:       storagev1.VolumeAttachmentSpec{Attacher: "ebs.csi.aws.com", NodeName: "node-2", Source: storagev1.VolumeAttachmentSource{PersistentVolumeName: &boundVolume}},
+			Status:     storagev1.VolumeAttachmentStatus{Attached: true, DetachError: &storagev1.VolumeError{Message: "volume is busy"}},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-released", DeletionTimestamp: &now, Finalizers: []string{FinalizerExternalProvisioner}},
+			Spec: corev1.PersistentVolumeSpec{
+				PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+				PersistentVolumeSource:        corev1.PersistentVolumeSource{CSI: &corev1.CSIPersistentVolumeSource{Driver: "ebs.csi.aws.com"}},
+			},
+			Status: corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+		},
+		&corev1.PersistentVolume{
+			ObjectMeta: metav1.ObjectMeta{Name: "pv-orphaned", DeletionTimestamp: &now, Finalizers: []string{FinalizerExternalProvisioner}, Annotations: map[string]string{annotationProvisionedBy: "nfs.csi.k8s.io"}},
+			Status:     corev1.PersistentVolumeStatus{Phase: corev1.VolumeReleased},
+		},
+		&storagev1.CSIDriver{ObjectMeta: metav1.ObjectMeta{Name: "ebs.csi.aws.com"}},
+		&coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "ebs-csi-aws-com"},
+			Spec:       coordinationv1.LeaseSpec{RenewTime: &stale, LeaseDurationSeconds: &leaseDuration},
+		},
+	)
+
+	stuckObjects := []metrics.StuckObject{
+		{Namespace: "apps", Name: "data", GroupVersionResource: pvcResource, Finalizers: []string{FinalizerPVCProtection}},
+		{Namespace: "apps", Name: "cache-scratch", GroupVersionResource: pvcResource, Finalizers: []string{FinalizerPVCProtection}},
+		{Namespace: "apps", Name: "unused", GroupVersionResource: pvcResource, Finalizers: []string{FinalizerPVCProtection}},
+		{Name: boundVolume, GroupVersionResource: pvResource, Finalizers: []string{FinalizerPVProtection}},
+		{Name: "pv-released", GroupVersionResource: pvResource, Finalizers: []string{FinalizerExternalProvisioner}},
+		{Name: "pv-orphaned", GroupVersionResource: pvResource, Finalizers: []string{FinalizerExternalProvisioner}},
+		// Other finalizers and objects that are already gone are not diagnosed.
+		{Namespace: "apps", Name: "other", GroupVersionResource: pvcResource, Finalizers: []string{"example.com/backup"}},
+		{Namespace: "apps", Name: "deleted", GroupVersionResource: pvcResource, Finalizers: []string{FinalizerPVCProtection}},
+	}
+
+	diagnoses, err := AnalyzeVolumes(clientset, stuckObjects)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	byName := make(map[string]VolumeDiagnosis)
+	for _, diagnosis := range diagnoses {
+		byName[diagnosis.Name] = diagnosis
+	}
+	if len(byName) != 6 {
+		t.Fatalf("Expected 6 diagnoses, got %+v", diagnoses)
+	}
+
+	if pods := byName["data"].Pods; len(pods) != 1 || pods[0] != "apps/db-0" {
+		t.Errorf("Expected claim data to be used by apps/db-0, got %v", pods)
+	}
+	if pods := byName["cache-scratch"].Pods; len(pods) != 1 || pods[0] != "apps/cache" {
+		t.Errorf("Expected the ephemeral claim to be used by apps/cache, got %v", pods)
+	}
+	if pods := byName["unused"].Pods; len(pods) != 0 {
+		t.Errorf("Expected claim unused to have no pods, got %v", pods)
+	}
+
+	bound := byName[boundVolume]
+	if len(bound.VolumeAttachments) != 1 || bound.VolumeAttachments[0] != "csi-abc" {
+		t.Errorf("Expected %s to be attached by csi-abc, got %v", boundVolume, bound.VolumeAttachments)
+	}
+	assertBlocker(t, bound, "no longer exists")
+	assertBlocker(t, bound, "volume is busy")
+
+	tests := []struct {
+		volume     string
+		wantStatus string
+	}{
+		{volume: "pv-released", wantStatus: metrics.ControllerNotReady},
+		{volume: "pv-orphaned", wantStatus: metrics.ControllerMissing},
+	}
+	for _, tt := range tests {
+		driver := byName[tt.volume].Driver
+		if driver == nil || driver.Status != tt.wantStatus {
+			t.Errorf("Expected the driver of %s to be %s, got %+v", tt.volume, tt.wantStatus, driver)
+		}
+	}
+
+	// The handler serves the published diagnoses without listing Pods, VolumeAttachments or Leases again.
+	actions := len(clientset.Actions())
+	recorder := httptest.NewRecorder()
+	VolumeDiagnosesHandler(recorder, httptest.NewRequest(http.MethodGet, "/volume-diagnoses", nil))
+	var served []VolumeDiagnosis
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(served) != len(diagnoses) {
+		t.Errorf("Expected the handler to serve %d diagnoses, got %+v", len(diagnoses), served)
+	}
+	if len(clientset.Actions()) != actions {
+		t.Errorf("Expected the handler not to query the cluster, got %v", clientset.Actions()[actions:])
+	}
+}
+
+func TestProvisionerLeaseName(t *testing.T) {
+	tests := map[string]string{
+		"ebs.csi.aws.com":            "ebs-csi-aws-com",
+		"rook-ceph.rbd.csi.ceph.com": "rook-ceph-rbd-csi-ceph-com",
+		"example.com/":               "example-com-X",
+	}
+	for driver, want := range tests {
+		if got := provisionerLeaseName(driver); got != want {
+			t.Errorf("Expected the lease of %s to be %s, got %s", driver, want, got)
+		}
+	}
+}
+
+// assertBlocker fails the test unless one of the diagnosis's blockers contains substr.
+func assertBlocker(t *testing.T, diagnosis VolumeDiagnosis, substr string) {
+	t.Helper()
+	for _, blocker := range diagnosis.Blockers {
+		if strings.Contains(blocker, substr) {
+			return
+		}
+	}
+	t.Errorf("Expected a blocker of %s containing %q, got %v", diagnosis.Name, substr, diagnosis.Blockers)
+}
@@ -0,0 +1,292 @@
+package approval
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/audit"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+)
+
+var logger = logging.SetupLogging()
+
+// Statuses of an approval request.
+const (
+	StatusPending  = "pending"
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusExpired  = "expired"
+)
+
+// Request asks for approval to run a remediation action on a stuck object.
+type Request struct {
+	ID          string              `json:"id"`
+	Object      metrics.StuckObject `json:"object"`
+	Action      string              `json:"action"`
+	Rule        string              `json:"rule"`
+	Reason      string              `json:"reason"`
+	Status      string              `json:"status"`
+	RequestedAt time.Time           `json:"requestedAt"`
+	DecidedAt   *time.Time          `json:"decidedAt,omitempty"`
+	DecidedBy   string              `json:"decidedBy,omitempty"`
+	Comment     string              `json:"comment,omitempty"`
+	ExpiresAt   *time.Time          `json:"expiresAt,omitempty"`
+}
+
+// Queue holds the approval requests of the objects currently eligible for remediation.
+type Queue struct {
+	mu       sync.Mutex
+	requests map[string]*Request
+	expiry   time.Duration
+	auditor  *audit.Auditor
+	// onApprove is called after a request is approved, so the approved action runs without waiting for the next cleanup cycle.
+	onApprove func()
+}
+
+// NewQueue returns an empty queue whose approvals expire after expiry. Every decision is recorded with the auditor.
+func NewQueue(expiry time.Duration, auditor *audit.Auditor) *Queue {
+	return &Queue{
+		requests: make(map[string]*Request),
+		expiry:   expiry,
+		auditor:  auditor,
+	}
+}
+
+// OnApprove sets a function called in the background after each approval, typically to run remediation at once.
+func (q *Queue) OnApprove(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.onApprove = fn
+}
+
+// Check reports whether an action on an object has been approved and the approval has not expired.
+// When there is no request for the action yet, or its approval expired, a pending request is queued.
+func (q *Queue) Check(obj metrics.StuckObject, action, rule, reason string, now time.Time) (bool, string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := string(obj.UID)
+	request, ok := q.requests[id]
+	if ok && request.Status == StatusApproved && request.ExpiresAt != nil && now.After(*request.ExpiresAt) {
+		logger.Infof("Approval of %s on %s %s in namespace %s expired at %s", request.Action, obj.Resource, obj.Name, obj.Namespace, request.ExpiresAt)
+		request.Status = StatusExpired
+		q.record(request, "")
+	}
+	if !ok || request.Action != action || request.Status == StatusExpired {
+		request = &Request{
+			ID:          id,
+			Object:      obj,
+			Action:      action,
+			Rule:        rule,
+			Reason:      reason,
+			Status:      StatusPending,
+			RequestedAt: now,
+		}
+		q.requests[id] = request
+		logger.Infof("Queued %s of %s %s in namespace %s for approval as %s", action, obj.Resource, obj.Name, obj.Namespace, id)
+		q.record(request, "")
+	}
+	request.Object = obj
+
+	switch request.Status {
+	case StatusApproved:
+		return true, ""
+	case StatusRejected:
+		return false, fmt.Sprintf("rejected by %s: %s", request.DecidedBy, request.Comment)
+	default:
+		return false, "awaiting approval of request " + id
+	}
+}
+
+// Complete removes a request once its approved action has been executed, so a new approval is needed next time.
+func (q *Queue) Complete(obj metrics.StuckObject) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.requests, string(obj.UID))
+}
+
+// Prune removes the requests of objects that are no longer eligible for remediation.
+func (q *Queue) Prune(eligible map[string]bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for id, request := range q.requests {
+		if !eligible[id] {
+			logger.Debugf("Removing approval request %s for %s %s, which is no longer eligible", id, request.Object.Resource, request.Object.Name)
+			delete(q.requests, id)
+		}
+	}
+}
+
+// List returns a copy of the requests, oldest first.
+func (q *Queue) List() []Request {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	requests := make([]Request, 0, len(q.requests))
+	for _, request := range q.requests {
+		requests = append(requests, *request)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if !requests[i].RequestedAt.Equal(requests[j].RequestedAt) {
+			return requests[i].RequestedAt.Before(requests[j].RequestedAt)
+		}
+		return requests[i].ID < requests[j].ID
+	})
+	return requests
+}
+
+// Approve approves a pending request. The approval expires after the queue's expiry window.
+func (q *Queue) Approve(id, user string, now time.Time) (Request, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	request, ok := q.requests[id]
+	if !ok {
+		return Request{}, fmt.Errorf("approval request %s not found", id)
+	}
+	if request.Status != StatusPending {
+		return Request{}, fmt.Errorf("approval request %s is %s, not pending", id, request.Status)
+	}
+
+	expiresAt := now.Add(q.expiry)
+	request.Status = StatusApproved
+	request.DecidedAt = &now
+	request.DecidedBy = user
+	request.ExpiresAt = &expiresAt
+	logger.Infof("Request %s to %s %s %s in namespace %s approved by %s until %s", id, request.Action, request.Object.Resource, request.Object.Name, request.Object.Namespace, user, expiresAt)
+	q.record(request, user)
+	return *request, nil
+}
+
+// Reject rejects a pending request with a reason. The action is not run while the object remains eligible.
+func (q *Queue) Reject(id, user, comment string, now time.Time) (Request, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	request, ok := q.requests[id]
+	if !ok {
+		return Request{}, fmt.Errorf("approval request %s not found", id)
+	}
+	if request.Status != StatusPending {
+		return Request{}, fmt.Errorf("approval request %s is %s, not pending", id, request.Status)
+	}
+
+	request.Status = StatusRejected
+	request.DecidedAt = &now
+	request.DecidedBy = user
+	request.Comment = comment
+	logger.Infof("Request %s to %s %s %s in namespace %s rejected by %s: %s", id, request.Action, request.Object.Resource, request.Object.Name, request.Object.Namespace, user, comment)
+	q.record(request, user)
+	return *request, nil
+}
+
+// record appends a change of a request's status to the audit trail. The caller must hold q.mu.
+func (q *Queue) record(request *Request, user string) {
+	q.auditor.Record(audit.Entry{
+		Time:                 time.Now(),
+		Action:               request.Action,
+		Rule:                 request.Rule,
+		Reason:               request.Reason,
+		Result:               "approval " + request.Status,
+		Error:                request.Comment,
+		User:                 user,
+		GroupVersionResource: request.Object.GroupVersionResource,
+		Namespace:            request.Object.Namespace,
+		Name:                 request.Object.Name,
+		UID:                  request.Object.UID,
+		Finalizers:           request.Object.Finalizers,
+	})
+}
+
+// decision is the body of an approve or reject request. The deciding user is the authenticated user, never
+// a field of the body.
+type decision struct {
+	Reason string `json:"reason"`
+}
+
+// ListHandler returns the approval requests as JSON.
+func (q *Queue) ListHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for approval requests")
+	writeJSON(w, http.StatusOK, q.List())
+}
+
+// ApproveHandler approves the request named by the id path value on behalf of the authenticated user, then
+// triggers the approved action.
+func (q *Queue) ApproveHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := readDecision(w, r); !ok {
+		return
+	}
+	request, err := q.Approve(r.PathValue("id"), user, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	q.mu.Lock()
+	onApprove := q.onApprove
+	q.mu.Unlock()
+	if onApprove != nil {
+		go onApprove()
+	}
+	writeJSON(w, http.StatusOK, request)
+}
+
+// RejectHandler rejects the request named by the id path value. A reason is required.
+func (q *Queue) RejectHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireUser(w, r)
+	if !ok {
+		return
+	}
+	body, ok := readDecision(w, r)
+	if !ok {
+		return
+	}
+	if body.Reason == "" {
+		http.Error(w, "A reason is required to reject a request", http.StatusBadRequest)
+		return
+	}
+	request, err := q.Reject(r.PathValue("id"), user, body.Reason, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, request)
+}
+
+// requireUser returns the user authenticated for a decision, refusing requests that did not pass through
+// Authenticator.Wrap.
+func requireUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	user := authenticatedUser(r)
+	if user == "" {
+		http.Error(w, "The request is not authenticated", http.StatusUnauthorized)
+		return "", false
+	}
+	return user, true
+}
+
+// readDecision decodes the optional body of an approve or reject request.
+func readDecision(w http.ResponseWriter, r *http.Request) (decision, bool) {
+	var body decision
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, fmt.Sprintf("Invalid request body: %v", err), http.StatusBadRequest)
+		return body, false
+	}
+	return body, true
+}
+
+// writeJSON writes a value as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		logger.Errorf("Failed to encode response: %v", err)
+	}
+}
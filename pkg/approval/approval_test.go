@@ -0,0 +1,171 @@
+package approval
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/audit"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+var widget = metrics.StuckObject{
+	GroupVersionResource: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"},
+	Namespace:            "team-a",
+	Name:                 "widget",
+	UID:                  "uid-1",
+	Finalizers:           []string{"example.com/cleanup"},
+}
+
+func TestQueue(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditor, err := audit.NewAuditor(kubernetesfake.NewSimpleClientset(), filename, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	queue := NewQueue(time.Hour, auditor)
+	now := time.Now()
+
+	if approved, reason := queue.Check(widget, "forceDelete", "default", "matched rule default", now); approved || !strings.Contains(reason, "awaiting approval") {
+		t.Fatalf("Expected a new request to await approval, got %t (%s)", approved, reason)
+	}
+	if _, err := queue.Approve("missing", "alice", now); err == nil {
+		t.Errorf("Expected approving an unknown request to fail")
+	}
+	if _, err := queue.Approve("uid-1", "alice", now); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := queue.Reject("uid-1", "bob", "too late", now); err == nil {
+		t.Errorf("Expected rejecting an approved request to fail")
+	}
+	if approved, reason := queue.Check(widget, "forceDelete", "default", "matched rule default", now.Add(time.Minute)); !approved {
+		t.Errorf("Expected an approved request to proceed, got %s", reason)
+	}
+
+	// An approval not used within the window expires and the action is queued again.
+	if approved, _ := queue.Check(widget, "forceDelete", "default", "matched rule default", now.Add(2*time.Hour)); approved {
+		t.Errorf("Expected an expired approval not to proceed")
+	}
+	if requests := queue.List(); len(requests) != 1 || requests[0].Status != StatusPending {
+		t.Fatalf("Expected the expired approval to be queued again, got %+v", requests)
+	}
+
+	if _, err := queue.Reject("uid-1", "bob", "owned by a live controller", now); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if approved, reason := queue.Check(widget, "forceDelete", "default", "matched rule default", now); approved || !strings.Contains(reason, "owned by a live controller") {
+		t.Errorf("Expected a rejected request not to proceed with its reason, got %t (%s)", approved, reason)
+	}
+
+	queue.Prune(map[string]bool{})
+	if requests := queue.List(); len(requests) != 0 {
+		t.Errorf("Expected requests for objects no longer eligible to be pruned, got %+v", requests)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer file.Close()
+	var results []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry audit.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected each line to be a JSON entry, got %q: %v", scanner.Text(), err)
+		}
+		results = append(results, entry.Result)
+	}
+	want := []string{"approval pending", "approval approved", "approval expired", "approval pending", "approval rejected"}
+	if strings.Join(results, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected audit results %v, got %v", want, results)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	queue := NewQueue(time.Hour, nil)
+	queue.Check(widget, "removeFinalizers", "default", "matched rule default", time.Now())
+	approved := make(chan struct{}, 1)
+	queue.OnApprove(func() { approved <- struct{}{} })
+
+	auth := NewAuthenticator(newReviewClientset())
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /approvals", auth.Wrap(queue.ListHandler))
+	mux.HandleFunc("POST /approvals/{id}/approve", auth.Wrap(queue.ApproveHandler))
+	mux.HandleFunc("POST /approvals/{id}/reject", auth.Wrap(queue.RejectHandler))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{name: "list without token", method: http.MethodGet, path: "/approvals", wantStatus: http.StatusUnauthorized},
+		{name: "list with invalid token", method: http.MethodGet, path: "/approvals", token: "forged", wantStatus: http.StatusUnauthorized},
+		{name: "list", method: http.MethodGet, path: "/approvals", token: "alice-token", wantStatus: http.StatusOK},
+		{name: "approve without access", method: http.MethodPost, path: "/approvals/uid-1/approve", token: "mallory-token", wantStatus: http.StatusForbidden},
+		{name: "reject without reason", method: http.MethodPost, path: "/approvals/uid-1/reject", token: "alice-token", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "approve unknown", method: http.MethodPost, path: "/approvals/uid-2/approve", token: "alice-token", wantStatus: http.StatusConflict},
+		// The deciding user comes from the token, whatever the body claims.
+		{name: "approve", method: http.MethodPost, path: "/approvals/uid-1/approve", token: "alice-token", body: `{"user":"bob"}`, wantStatus: http.StatusOK},
+		{name: "approve twice", method: http.MethodPost, path: "/approvals/uid-1/approve", token: "alice-token", wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			recorder := httptest.NewRecorder()
+			mux.ServeHTTP(recorder, request)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, recorder.Code, recorder.Body.String())
+			}
+		})
+	}
+
+	requests := queue.List()
+	if len(requests) != 1 || requests[0].Status != StatusApproved || requests[0].DecidedBy != "alice" || requests[0].ExpiresAt == nil {
+		t.Errorf("Expected the request to be approved by alice with an expiry, got %+v", requests)
+	}
+	select {
+	case <-approved:
+	case <-time.After(time.Second):
+		t.Errorf("Expected the approval to trigger remediation")
+	}
+}
+
+// newReviewClientset returns a clientset whose TokenReviews authenticate alice-token as alice and mallory-token
+// as mallory, and whose SubjectAccessReviews only allow alice.
+func newReviewClientset() *kubernetesfake.Clientset {
+	clientset := kubernetesfake.NewSimpleClientset()
+	users := map[string]string{"alice-token": "alice", "mallory-token": "mallory"}
+	clientset.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		if user, ok := users[review.Spec.Token]; ok {
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: user}}
+		}
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		review.Status.Allowed = review.Spec.User == "alice" && review.Spec.NonResourceAttributes != nil
+		return true, review, nil
+	})
+	return clientset
+}
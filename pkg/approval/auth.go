@@ -0,0 +1,132 @@
+package approval
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// userKey is the request context key of the authenticated user.
+type userKey struct{}
+
+// Authenticator authenticates approval API requests by their bearer token with a TokenReview and authorizes
+// them with a SubjectAccessReview on the request's path, so approvers are granted access with RBAC
+// nonResourceURLs such as "/approvals/*".
+type Authenticator struct {
+	clientset kubernetes.Interface
+}
+
+// NewAuthenticator returns an authenticator reviewing tokens and access with the given clientset.
+func NewAuthenticator(clientset kubernetes.Interface) *Authenticator {
+	return &Authenticator{clientset: clientset}
+}
+
+// Wrap rejects requests without a valid bearer token with 401 and requests the token's user may not make with
+// 403. Allowed requests are passed to next with the authenticated user in their context.
+func (a *Authenticator) Wrap(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "A bearer token is required", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := r.Context()
+		review, err := a.clientset.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			logger.Errorf("Error reviewing the token of an approval request: %v", err)
+			http.Error(w, "Failed to authenticate the request", http.StatusInternalServerError)
+			return
+		}
+		if !review.Status.Authenticated {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		user := review.Status.User
+
+		extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for key, value := range user.Extra {
+			extra[key] = authorizationv1.ExtraValue(value)
+		}
+		access, err := a.clientset.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   user.Username,
+				UID:    user.UID,
+				Groups: user.Groups,
+				Extra:  extra,
+				NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+					Path: r.URL.Path,
+					Verb: strings.ToLower(r.Method),
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			logger.Errorf("Error reviewing the access of %s to %s %s: %v", user.Username, r.Method, r.URL.Path, err)
+			http.Error(w, "Failed to authorize the request", http.StatusInternalServerError)
+			return
+		}
+		if !access.Status.Allowed {
+			logger.Warnf("Denied %s %s to %s: %s", r.Method, r.URL.Path, user.Username, access.Status.Reason)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(context.WithValue(ctx, userKey{}, user.Username)))
+	}
+}
+
+// bearerToken returns the token of a request's Authorization header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// authenticatedUser returns the user Wrap authenticated for a request.
+func authenticatedUser(r *http.Request) string {
+	user, _ := r.Context().Value(userKey{}).(string)
+	return user
+}
+
+// Serve serves the approval API on its own port, separate from the unauthenticated metrics server, with every
+// request authenticated and authorized by auth. TLS is used when certFile and keyFile are set.
+func (q *Queue) Serve(auth *Authenticator, port int, certFile, keyFile string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /approvals", auth.Wrap(q.ListHandler))
+	mux.HandleFunc("POST /approvals/{id}/approve", auth.Wrap(q.ApproveHandler))
+	mux.HandleFunc("POST /approvals/{id}/reject", auth.Wrap(q.RejectHandler))
+
+	srv := &http.Server{
+		Addr:         ":" + strconv.Itoa(port),
+		Handler:      mux,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  15 * time.Second,
+	}
+
+	var err error
+	if certFile != "" && keyFile != "" {
+		logger.Infof("Approval server starting with TLS on port %d", port)
+		err = srv.ListenAndServeTLS(certFile, keyFile)
+	} else {
+		logger.Warnf("Approval server starting without TLS on port %d; bearer tokens are sent in clear text", port)
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
+		logger.Fatalf("Approval server failed to start: %v", err)
+	}
+}
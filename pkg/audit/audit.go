@@ -0,0 +1,234 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/version"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var logger = logging.SetupLogging()
+
+// component is the source reported on the Events emitted by the inspector.
+const component = "k8s-deletion-inspector"
+
+// Event reasons.
+const (
+	ReasonFinalizersRemoved  = "FinalizersRemoved"
+	ReasonForceDeleted       = "ForceDeleted"
+	ReasonNamespaceFinalized = "NamespaceFinalized"
+	ReasonRemediationFailed  = "RemediationFailed"
+)
+
+// Results of a remediation action.
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+	ResultAlerted   = "alerted"
+	ResultSkipped   = "skipped"
+)
+
+// Entry is a single record of the audit trail, written for every remediation action whatever its result.
+type Entry struct {
+	Time                 time.Time                   `json:"time"`
+	Version              string                      `json:"version"`
+	Action               string                      `json:"action"`
+	Rule                 string                      `json:"rule,omitempty"`
+	Reason               string                      `json:"reason,omitempty"`
+	DryRun               bool                        `json:"dryRun"`
+	Result               string                      `json:"result"`
+	Error                string                      `json:"error,omitempty"`
+	User                 string                      `json:"user,omitempty"`
+	GroupVersionResource schema.GroupVersionResource `json:"groupVersionResource"`
+	Namespace            string                      `json:"namespace,omitempty"`
+	Name                 string                      `json:"name"`
+	UID                  types.UID                   `json:"uid"`
+	Age                  string                      `json:"age"`
+	Finalizers           []string                    `json:"finalizers,omitempty"`
+	RemovedFinalizers    []string                    `json:"removedFinalizers,omitempty"`
+	Deleted              bool                        `json:"deleted,omitempty"`
+	Backup               string                      `json:"backup,omitempty"`
+}
+
+// Auditor appends entries to a JSON-lines audit file, or writes them to stdout, and emits Kubernetes Events for the changes made.
+type Auditor struct {
+	clientset k8s.ClientsetInterface
+	events    bool
+
+	mu    sync.Mutex
+	out   io.Writer
+	kinds map[schema.GroupVersionResource]string
+}
+
+// NewAuditor returns an auditor appending to filename, writing to stdout when filename is "-", or writing no
+// audit records when filename is empty or "none".
+// Events are emitted on the affected objects and their namespaces when events is set.
+func NewAuditor(clientset k8s.ClientsetInterface, filename string, events bool) (*Auditor, error) {
+	auditor := &Auditor{
+		clientset: clientset,
+		events:    events,
+		kinds:     make(map[schema.GroupVersionResource]string),
+	}
+	if filename == "" || filename == "none" {
+		logger.Warnln("The remediation audit file is disabled")
+		return auditor, nil
+	}
+	if filename == "-" {
+		logger.Infoln("Writing remediation audit records to stdout")
+		auditor.out = os.Stdout
+		return auditor, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o700); err != nil {
+		return nil, fmt.Errorf("error creating audit directory for %s: %v", filename, err)
+	}
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600) // #nosec G304 -- the audit file path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("error opening audit file %s: %v", filename, err)
+	}
+	logger.Infof("Appending remediation audit records to %s", filename)
+	auditor.out = file
+	return auditor, nil
+}
+
+// Record appends an entry to the audit file and, for finalizer removals and deletions that were not
+// dry-run, emits an Event on the object and on its namespace.
+func (a *Auditor) Record(entry Entry) {
+	if a == nil {
+		return
+	}
+	entry.Version = version.Version
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.out != nil {
+		if err := json.NewEncoder(a.out).Encode(entry); err != nil {
+			logger.Errorf("Error writing audit record for %s %s in namespace %s: %v", entry.GroupVersionResource.Resource, entry.Name, entry.Namespace, err)
+		}
+	}
+
+	if !a.events || entry.DryRun {
+		return
+	}
+	reason, eventType := eventReason(entry)
+	if reason == "" {
+		return
+	}
+	message := eventMessage(entry)
+
+	kind, err := a.kindFor(entry.GroupVersionResource)
+	if err != nil {
+		logger.Errorf("Error resolving the kind of resource %s, not emitting an event: %v", entry.GroupVersionResource, err)
+	} else {
+		a.emit(entry.Namespace, corev1.ObjectReference{
+			Kind:       kind,
+			APIVersion: entry.GroupVersionResource.GroupVersion().String(),
+			Namespace:  entry.Namespace,
+			Name:       entry.Name,
+			UID:        entry.UID,
+		}, reason, eventType, message)
+	}
+	if entry.Namespace != "" {
+		a.emit(entry.Namespace, corev1.ObjectReference{Kind: "Namespace", APIVersion: "v1", Name: entry.Namespace}, reason, eventType,
+			fmt.Sprintf("%s %s: %s", entry.GroupVersionResource.GroupResource(), entry.Name, message))
+	}
+}
+
+// eventReason returns the reason and type of the Event for an entry, or an empty reason when none is emitted.
+func eventReason(entry Entry) (string, string) {
+	switch {
+	case !policy.Remediates(entry.Action):
+		return "", ""
+	case entry.Result == ResultFailed:
+		return ReasonRemediationFailed, corev1.EventTypeWarning
+	case entry.Result != ResultSucceeded:
+		return "", ""
+	case entry.Deleted:
+		return ReasonForceDeleted, corev1.EventTypeWarning
+	case entry.Action == policy.ActionFinalizeNamespace && len(entry.RemovedFinalizers) > 0:
+		return ReasonNamespaceFinalized, corev1.EventTypeWarning
+	case len(entry.RemovedFinalizers) > 0:
+		return ReasonFinalizersRemoved, corev1.EventTypeWarning
+	}
+	return "", ""
+}
+
+// eventMessage describes an entry for an Event.
+func eventMessage(entry Entry) string {
+	var parts []string
+	switch {
+	case entry.Result == ResultFailed:
+		parts = append(parts, fmt.Sprintf("%s failed: %s", entry.Action, entry.Error))
+	case entry.Deleted:
+		parts = append(parts, "force deleted")
+	case entry.Action == policy.ActionFinalizeNamespace:
+		parts = append(parts, "finalized the empty namespace")
+	default:
+		parts = append(parts, "removed finalizers")
+	}
+	if len(entry.RemovedFinalizers) > 0 {
+		parts = append(parts, "finalizers removed: "+strings.Join(entry.RemovedFinalizers, ", "))
+	}
+	parts = append(parts, "deleting for "+entry.Age)
+	if entry.Rule != "" {
+		parts = append(parts, "policy rule "+entry.Rule)
+	}
+	if entry.Backup != "" {
+		parts = append(parts, "backup "+entry.Backup)
+	}
+	return fmt.Sprintf("%s %s: %s", component, entry.Version, strings.Join(parts, "; "))
+}
+
+// emit creates an Event on an object in the given namespace, or in the default namespace when it is empty.
+// The caller must hold a.mu.
+func (a *Auditor) emit(namespace string, involved corev1.ObjectReference, reason, eventType, message string) {
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
+	now := metav1.Now()
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			// Named like the Events of client-go's event recorder.
+			Name:      fmt.Sprintf("%v.%x", involved.Name, now.UnixNano()),
+			Namespace: namespace,
+		},
+		InvolvedObject: involved,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         corev1.EventSource{Component: component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if _, err := a.clientset.CoreV1().Events(namespace).Create(context.Background(), event, metav1.CreateOptions{}); err != nil {
+		logger.Errorf("Error creating event %s on %s %s: %v", reason, involved.Kind, involved.Name, err)
+	}
+}
+
+// kindFor returns the kind of a resource, caching the answer from discovery. The caller must hold a.mu.
+func (a *Auditor) kindFor(resource schema.GroupVersionResource) (string, error) {
+	if kind, ok := a.kinds[resource]; ok {
+		return kind, nil
+	}
+	kind, err := k8s.GetKindForResource(a.clientset, resource)
+	if err != nil {
+		return "", err
+	}
+	a.kinds[resource] = kind
+	return kind, nil
+}
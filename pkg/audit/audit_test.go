@@ -0,0 +1,96 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRecord(t *testing.T) {
+	clientset := kubernetesfake.NewSimpleClientset()
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "example.com/v1",
+		APIResources: []metav1.APIResource{{Name: "widgets", Kind: "Widget", Namespaced: true}},
+	}}
+	filename := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	auditor, err := NewAuditor(clientset, filename, true)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	widgets := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	auditor.Record(Entry{
+		Time:                 time.Now(),
+		Action:               "forceDelete",
+		Rule:                 "default",
+		Result:               ResultSucceeded,
+		GroupVersionResource: widgets,
+		Namespace:            "team-a",
+		Name:                 "widget",
+		Age:                  "80h0m0s",
+		RemovedFinalizers:    []string{"example.com/cleanup"},
+		Deleted:              true,
+	})
+	// Dry-run and alert entries are audited but emit no Events.
+	auditor.Record(Entry{Action: "forceDelete", DryRun: true, Result: ResultSucceeded, GroupVersionResource: widgets, Namespace: "team-a", Name: "other", Deleted: true})
+	auditor.Record(Entry{Action: "alert", Result: ResultAlerted, GroupVersionResource: widgets, Namespace: "team-a", Name: "third"})
+
+	file, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer file.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Expected each line to be a JSON entry, got %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 audit entries, got %d", len(entries))
+	}
+
+	events, err := clientset.CoreV1().Events("team-a").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(events.Items) != 2 {
+		t.Fatalf("Expected an event on the object and one on its namespace, got %d", len(events.Items))
+	}
+	kinds := map[string]bool{}
+	for _, event := range events.Items {
+		kinds[event.InvolvedObject.Kind] = true
+		if event.Reason != ReasonForceDeleted || !strings.Contains(event.Message, "example.com/cleanup") {
+			t.Errorf("Expected a %s event mentioning the removed finalizer, got %s: %s", ReasonForceDeleted, event.Reason, event.Message)
+		}
+	}
+	if !kinds["Widget"] || !kinds["Namespace"] {
+		t.Errorf("Expected events on the Widget and the Namespace, got %v", kinds)
+	}
+}
+
+func TestNewAuditorOutput(t *testing.T) {
+	clientset := kubernetesfake.NewSimpleClientset()
+	tests := map[string]bool{"": false, "none": false, "-": true}
+	for filename, wantOutput := range tests {
+		auditor, err := NewAuditor(clientset, filename, false)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", filename, err)
+		}
+		if (auditor.out != nil) != wantOutput {
+			t.Errorf("Expected audit records for %q to be written: %t, got output %v", filename, wantOutput, auditor.out)
+		}
+	}
+}
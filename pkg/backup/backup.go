@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/config"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+)
+
+var logger = logging.SetupLogging()
+
+// Backends a backup can be written to.
+const (
+	BackendNone      = "none"
+	BackendDirectory = "directory"
+	BackendConfigMap = "configmap"
+	BackendSecret    = "secret"
+)
+
+// Backup is a snapshot of an object taken before remediation changed it.
+type Backup struct {
+	ID                   string                      `json:"id"`
+	TakenAt              time.Time                   `json:"takenAt"`
+	Action               string                      `json:"action"`
+	Rule                 string                      `json:"rule,omitempty"`
+	GroupVersionResource schema.GroupVersionResource `json:"groupVersionResource"`
+	Namespace            string                      `json:"namespace,omitempty"`
+	Name                 string                      `json:"name"`
+	UID                  types.UID                   `json:"uid"`
+	Finalizers           []string                    `json:"finalizers,omitempty"`
+	// RemovedFinalizers are the finalizers remediation removed, which a restored object does not get back.
+	RemovedFinalizers []string                   `json:"removedFinalizers,omitempty"`
+	Object            *unstructured.Unstructured `json:"object"`
+}
+
+// Backend stores and retrieves backups.
+type Backend interface {
+	// Save stores a backup, returning where it was written.
+	Save(backup *Backup) (string, error)
+	// Load returns the backup with the given ID.
+	Load(id string) (*Backup, error)
+	// List returns the IDs of the stored backups, oldest first.
+	List() ([]string, error)
+}
+
+// NewBackend returns the backend configured by BACKUP_BACKEND, or nil when backups are disabled.
+func NewBackend(clientset k8s.ClientsetInterface) (Backend, error) {
+	switch config.CFG.BackupBackend {
+	case BackendNone, "":
+		logger.Warnln("Pre-remediation backups are disabled")
+		return nil, nil
+	case BackendDirectory:
+		return NewDirectoryBackend(config.CFG.BackupDir)
+	case BackendConfigMap:
+		return NewConfigMapBackend(clientset, config.CFG.BackupNamespace, config.CFG.BackupName, false, config.CFG.BackupMaxBytes), nil
+	case BackendSecret:
+		return NewConfigMapBackend(clientset, config.CFG.BackupNamespace, config.CFG.BackupName, true, config.CFG.BackupMaxBytes), nil
+	default:
+		return nil, fmt.Errorf("unknown backup backend %q, expected 'none', 'directory', 'configmap' or 'secret'", config.CFG.BackupBackend)
+	}
+}
+
+// NewBackup snapshots an object. IDs sort chronologically and are valid ConfigMap keys and file names.
+// removedFinalizers are the finalizers the action removes.
+func NewBackup(obj *unstructured.Unstructured, gvr schema.GroupVersionResource, action, rule string, removedFinalizers []string, now time.Time) *Backup {
+	return &Backup{
+		ID:                   fmt.Sprintf("%s-%s", now.UTC().Format("20060102T150405.000000000Z"), obj.GetUID()),
+		TakenAt:              now,
+		Action:               action,
+		Rule:                 rule,
+		GroupVersionResource: gvr,
+		Namespace:            obj.GetNamespace(),
+		Name:                 obj.GetName(),
+		UID:                  obj.GetUID(),
+		Finalizers:           obj.GetFinalizers(),
+		RemovedFinalizers:    removedFinalizers,
+		Object:               obj.DeepCopy(),
+	}
+}
+
+// encode serializes a backup as YAML.
+func encode(backup *Backup) ([]byte, error) {
+	data, err := yaml.Marshal(backup)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding backup %s: %v", backup.ID, err)
+	}
+	return data, nil
+}
+
+// decode parses a backup from YAML.
+func decode(data []byte) (*Backup, error) {
+	backup := &Backup{}
+	if err := yaml.Unmarshal(data, backup); err != nil {
+		return nil, fmt.Errorf("error decoding backup: %v", err)
+	}
+	return backup, nil
+}
+
+// validID reports whether an ID is safe to use as a file name and ConfigMap key.
+func validID(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\") && !strings.HasPrefix(id, ".")
+}
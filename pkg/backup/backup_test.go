@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+)
+
+var widgets = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+
+// newWidget returns a deleting custom resource as returned by the API server.
+func newWidget(uid string) *unstructured.Unstructured {
+	widget := &unstructured.Unstructured{}
+	widget.SetAPIVersion("example.com/v1")
+	widget.SetKind("Widget")
+	widget.SetNamespace("default")
+	widget.SetName("widget-" + uid)
+	widget.SetUID(types.UID(uid))
+	widget.SetResourceVersion("42")
+	widget.SetFinalizers([]string{"example.com/cleanup"})
+	deletionTimestamp := metav1.NewTime(time.Now())
+	widget.SetDeletionTimestamp(&deletionTimestamp)
+	widget.Object["spec"] = map[string]interface{}{"size": "large"}
+	widget.Object["status"] = map[string]interface{}{"phase": "Deleting"}
+	return widget
+}
+
+func TestDirectoryBackend(t *testing.T) {
+	backend, err := NewDirectoryBackend(t.TempDir())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	saved := NewBackup(newWidget("uid-1"), widgets, "forceDelete", "default", nil, time.Now())
+	if _, err := backend.Save(saved); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ids, err := backend.List()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ids) != 1 || ids[0] != saved.ID {
+		t.Fatalf("Expected backup %s to be listed, got %v", saved.ID, ids)
+	}
+
+	loaded, err := backend.Load(saved.ID)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if loaded.GroupVersionResource != widgets || loaded.Object.GetName() != "widget-uid-1" || len(loaded.Finalizers) != 1 {
+		t.Errorf("Expected the backup to round trip, got %+v", loaded)
+	}
+
+	if _, err := backend.Load("../escape"); err == nil {
+		t.Error("Expected an error loading an id outside the backup directory")
+	}
+}
+
+func TestConfigMapBackendEvictsOldest(t *testing.T) {
+	clientset := kubernetesfake.NewSimpleClientset()
+	first := NewBackup(newWidget("uid-1"), widgets, "forceDelete", "default", nil, time.Now())
+	encoded, err := encode(first)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// Leave room for a single backup so saving the second evicts the first.
+	backend := NewConfigMapBackend(clientset, "inspector", "backups", false, len(encoded)+len(first.ID)+100)
+
+	if _, err := backend.Save(first); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	second := NewBackup(newWidget("uid-2"), widgets, "forceDelete", "default", nil, time.Now().Add(time.Second))
+	if _, err := backend.Save(second); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	ids, err := backend.List()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(ids) != 1 || ids[0] != second.ID {
+		t.Fatalf("Expected only the newest backup to be kept, got %v", ids)
+	}
+
+	tooSmall := NewConfigMapBackend(clientset, "inspector", "small", true, 10)
+	if _, err := tooSmall.Save(first); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("Expected a size limit error, got %v", err)
+	}
+}
+
+func TestConfigMapBackendRefusesSecrets(t *testing.T) {
+	secret := newWidget("uid-1")
+	secret.SetAPIVersion("v1")
+	secret.SetKind("Secret")
+	saved := NewBackup(secret, secretsResource, "forceDelete", "default", nil, time.Now())
+
+	backend := NewConfigMapBackend(kubernetesfake.NewSimpleClientset(), "inspector", "backups", false, 900000)
+	if _, err := backend.Save(saved); err == nil || !strings.Contains(err.Error(), "plain text") {
+		t.Errorf("Expected the configmap backend to refuse a secret, got %v", err)
+	}
+
+	backend = NewConfigMapBackend(kubernetesfake.NewSimpleClientset(), "inspector", "backups", true, 900000)
+	if _, err := backend.Save(saved); err != nil {
+		t.Errorf("Expected the secret backend to back up a secret, got %v", err)
+	}
+}
+
+func TestPrepareForRestore(t *testing.T) {
+	widget := newWidget("uid-1")
+	widget.SetFinalizers([]string{"example.com/cleanup", "example.com/protect"})
+	widget.SetOwnerReferences([]metav1.OwnerReference{
+		{APIVersion: "example.com/v1", Kind: "Gadget", Name: "live", UID: "owner-1"},
+		{APIVersion: "example.com/v1", Kind: "Gadget", Name: "gone", UID: "owner-2"},
+	})
+	saved := NewBackup(widget, widgets, "forceDelete", "default", []string{"example.com/cleanup"}, time.Now())
+
+	obj, err := PrepareForRestore(saved, func(ref metav1.OwnerReference) (bool, error) { return ref.UID == "owner-1", nil })
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if obj.GetUID() != "" || obj.GetResourceVersion() != "" || obj.GetDeletionTimestamp() != nil {
+		t.Errorf("Expected server-assigned fields to be cleared, got %v", obj.Object["metadata"])
+	}
+	if _, found := obj.Object["status"]; found {
+		t.Error("Expected status to be removed")
+	}
+	if size, _, _ := unstructured.NestedString(obj.Object, "spec", "size"); size != "large" {
+		t.Errorf("Expected spec to be kept, got %v", obj.Object["spec"])
+	}
+	if finalizers := obj.GetFinalizers(); len(finalizers) != 1 || finalizers[0] != "example.com/protect" {
+		t.Errorf("Expected only the finalizer remediation did not remove to be kept, got %v", finalizers)
+	}
+	if refs := obj.GetOwnerReferences(); len(refs) != 1 || refs[0].UID != "owner-1" {
+		t.Errorf("Expected only the reference to the live owner to be kept, got %v", refs)
+	}
+}
+
+func TestOwnerExists(t *testing.T) {
+	gadgets := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "gadgets"}
+	gadget := &unstructured.Unstructured{}
+	gadget.SetAPIVersion("example.com/v1")
+	gadget.SetKind("Gadget")
+	gadget.SetNamespace("default")
+	gadget.SetName("live")
+	gadget.SetUID("owner-1")
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{gadgets: "GadgetList"}, gadget)
+	mapper := meta.NewDefaultRESTMapper(nil)
+	mapper.Add(schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Gadget"}, meta.RESTScopeNamespace)
+
+	tests := []struct {
+		ref  metav1.OwnerReference
+		want bool
+	}{
+		{ref: metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Gadget", Name: "live", UID: "owner-1"}, want: true},
+		// An owner recreated under the same name is a different owner.
+		{ref: metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Gadget", Name: "live", UID: "owner-0"}, want: false},
+		{ref: metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Gadget", Name: "gone", UID: "owner-2"}, want: false},
+		{ref: metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Uninstalled", Name: "any", UID: "owner-3"}, want: false},
+	}
+	for _, tt := range tests {
+		got, err := ownerExists(dynamicClient, mapper, "default", tt.ref)
+		if err != nil {
+			t.Fatalf("Expected no error for %s %s, got %v", tt.ref.Kind, tt.ref.Name, err)
+		}
+		if got != tt.want {
+			t.Errorf("Expected owner %s %s (uid %s) to exist: %t, got %t", tt.ref.Kind, tt.ref.Name, tt.ref.UID, tt.want, got)
+		}
+	}
+}
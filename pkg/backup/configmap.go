@@ -0,0 +1,189 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+// secretsResource is the resource of the Secrets a ConfigMap backend refuses to back up.
+var secretsResource = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// ConfigMapBackend keeps backups as keys of a single ConfigMap or Secret in the inspector's namespace.
+// The oldest backups are evicted so the total size stays within maxBytes.
+type ConfigMapBackend struct {
+	clientset k8s.ClientsetInterface
+	namespace string
+	name      string
+	secret    bool
+	maxBytes  int
+}
+
+// NewConfigMapBackend returns a backend storing backups in the named ConfigMap, or Secret when secret is set.
+func NewConfigMapBackend(clientset k8s.ClientsetInterface, namespace, name string, secret bool, maxBytes int) *ConfigMapBackend {
+	logger.Infof("Writing pre-remediation backups to %s %s/%s (max %d bytes)", kindName(secret), namespace, name, maxBytes)
+	return &ConfigMapBackend{clientset: clientset, namespace: namespace, name: name, secret: secret, maxBytes: maxBytes}
+}
+
+// Save adds a backup, evicting the oldest backups if the total size would exceed maxBytes. Secrets are refused
+// by a ConfigMap backend, which would store their data in plain text.
+func (c *ConfigMapBackend) Save(backup *Backup) (string, error) {
+	if !c.secret && backup.GroupVersionResource == secretsResource {
+		return "", fmt.Errorf("refusing to back up secret %s/%s to a configmap in plain text, use the secret backend", backup.Namespace, backup.Name)
+	}
+	encoded, err := encode(backup)
+	if err != nil {
+		return "", err
+	}
+	key := backup.ID + backupExtension
+	if size := len(key) + len(encoded); size > c.maxBytes {
+		return "", fmt.Errorf("backup %s is %d bytes, larger than the %d byte limit", backup.ID, size, c.maxBytes)
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		data, existing, err := c.read()
+		if err != nil {
+			return err
+		}
+		data[key] = encoded
+		for _, evicted := range evict(data, c.maxBytes) {
+			logger.Warnf("Evicting backup %s from %s %s/%s to stay within %d bytes", evicted, kindName(c.secret), c.namespace, c.name, c.maxBytes)
+		}
+		return c.write(data, existing)
+	})
+	if err != nil {
+		return "", fmt.Errorf("error saving backup %s to %s %s/%s: %v", backup.ID, kindName(c.secret), c.namespace, c.name, err)
+	}
+	return fmt.Sprintf("%s %s/%s key %s", kindName(c.secret), c.namespace, c.name, key), nil
+}
+
+// Load returns the backup with the given ID.
+func (c *ConfigMapBackend) Load(id string) (*Backup, error) {
+	data, _, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	encoded, ok := data[id+backupExtension]
+	if !ok {
+		return nil, fmt.Errorf("backup %s not found in %s %s/%s", id, kindName(c.secret), c.namespace, c.name)
+	}
+	return decode(encoded)
+}
+
+// List returns the IDs of the stored backups, oldest first.
+func (c *ConfigMapBackend) List() ([]string, error) {
+	data, _, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(data))
+	for key := range data {
+		ids = append(ids, strings.TrimSuffix(key, backupExtension))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// read returns the stored backups keyed by file name, and the metadata of the ConfigMap or Secret if it exists.
+func (c *ConfigMapBackend) read() (map[string][]byte, *metav1.ObjectMeta, error) {
+	data := make(map[string][]byte)
+	if c.secret {
+		secret, err := c.clientset.CoreV1().Secrets(c.namespace).Get(context.Background(), c.name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return data, nil, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("error fetching secret %s/%s: %v", c.namespace, c.name, err)
+		}
+		for key, value := range secret.Data {
+			data[key] = value
+		}
+		return data, &secret.ObjectMeta, nil
+	}
+
+	configMap, err := c.clientset.CoreV1().ConfigMaps(c.namespace).Get(context.Background(), c.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return data, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching configmap %s/%s: %v", c.namespace, c.name, err)
+	}
+	for key, value := range configMap.Data {
+		data[key] = []byte(value)
+	}
+	return data, &configMap.ObjectMeta, nil
+}
+
+// write stores the backups, creating the ConfigMap or Secret if it does not exist yet. Updates are
+// conditional on the resourceVersion that was read, so concurrent writers conflict instead of losing backups.
+func (c *ConfigMapBackend) write(data map[string][]byte, existing *metav1.ObjectMeta) error {
+	objectMeta := metav1.ObjectMeta{
+		Name:      c.name,
+		Namespace: c.namespace,
+		Labels:    map[string]string{"app.kubernetes.io/managed-by": "k8s-deletion-inspector"},
+	}
+	exists := existing != nil
+	if exists {
+		objectMeta.ResourceVersion = existing.ResourceVersion
+	}
+
+	var err error
+	if c.secret {
+		secret := &corev1.Secret{ObjectMeta: objectMeta, Data: data}
+		if exists {
+			_, err = c.clientset.CoreV1().Secrets(c.namespace).Update(context.Background(), secret, metav1.UpdateOptions{})
+		} else {
+			_, err = c.clientset.CoreV1().Secrets(c.namespace).Create(context.Background(), secret, metav1.CreateOptions{})
+		}
+		return err
+	}
+
+	configMap := &corev1.ConfigMap{ObjectMeta: objectMeta, Data: make(map[string]string, len(data))}
+	for key, value := range data {
+		configMap.Data[key] = string(value)
+	}
+	if exists {
+		_, err = c.clientset.CoreV1().ConfigMaps(c.namespace).Update(context.Background(), configMap, metav1.UpdateOptions{})
+	} else {
+		_, err = c.clientset.CoreV1().ConfigMaps(c.namespace).Create(context.Background(), configMap, metav1.CreateOptions{})
+	}
+	return err
+}
+
+// evict removes the oldest backups until the total size is within maxBytes, returning the evicted keys.
+func evict(data map[string][]byte, maxBytes int) []string {
+	keys := make([]string, 0, len(data))
+	total := 0
+	for key, value := range data {
+		keys = append(keys, key)
+		total += len(key) + len(value)
+	}
+	sort.Strings(keys)
+
+	var evicted []string
+	for _, key := range keys {
+		if total <= maxBytes {
+			break
+		}
+		total -= len(key) + len(data[key])
+		delete(data, key)
+		evicted = append(evicted, key)
+	}
+	return evicted
+}
+
+// kindName returns the kind of object backups are stored in, for log and error messages.
+func kindName(secret bool) string {
+	if secret {
+		return "secret"
+	}
+	return "configmap"
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// backupExtension is the file extension of backups written to a directory.
+const backupExtension = ".yaml"
+
+// DirectoryBackend writes each backup to its own file in a local directory, such as a mounted PVC.
+type DirectoryBackend struct {
+	dir string
+}
+
+// NewDirectoryBackend returns a backend writing to dir, creating it if needed.
+func NewDirectoryBackend(dir string) (*DirectoryBackend, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("error creating backup directory %s: %v", dir, err)
+	}
+	logger.Infof("Writing pre-remediation backups to directory %s", dir)
+	return &DirectoryBackend{dir: dir}, nil
+}
+
+// Save writes a backup to a file named after its ID.
+func (d *DirectoryBackend) Save(backup *Backup) (string, error) {
+	data, err := encode(backup)
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(d.dir, backup.ID+backupExtension)
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		return "", fmt.Errorf("error writing backup %s: %v", filename, err)
+	}
+	return filename, nil
+}
+
+// Load reads the backup with the given ID.
+func (d *DirectoryBackend) Load(id string) (*Backup, error) {
+	if !validID(id) {
+		return nil, fmt.Errorf("invalid backup id %q", id)
+	}
+	filename := filepath.Join(d.dir, id+backupExtension)
+	data, err := os.ReadFile(filename) // #nosec G304 -- the id is validated not to leave the backup directory
+	if err != nil {
+		return nil, fmt.Errorf("error reading backup %s: %v", filename, err)
+	}
+	return decode(data)
+}
+
+// List returns the IDs of the backups in the directory, oldest first.
+func (d *DirectoryBackend) List() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, fmt.Errorf("error listing backup directory %s: %v", d.dir, err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), backupExtension) {
+			ids = append(ids, strings.TrimSuffix(entry.Name(), backupExtension))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
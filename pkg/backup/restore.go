@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+// Restore re-creates the object of a backup. Fields assigned by the API server are cleared so the object
+// can be created again; its spec, labels and annotations are kept. Owner references to owners that no longer
+// exist are dropped, so the garbage collector does not delete the restored object at once, and the finalizers
+// remediation removed are not restored, so the object does not get stuck on them again.
+func Restore(restConfig *rest.Config, backend Backend, id string) (*unstructured.Unstructured, error) {
+	backup, err := backend.Load(id)
+	if err != nil {
+		return nil, err
+	}
+	if backup.Object == nil {
+		return nil, fmt.Errorf("backup %s does not contain an object", id)
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating dynamic client: %v", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating discovery client: %v", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	obj, err := PrepareForRestore(backup, func(ref metav1.OwnerReference) (bool, error) {
+		return ownerExists(dynamicClient, mapper, backup.Namespace, ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Restoring %s %s in namespace %s from backup %s", backup.GroupVersionResource.Resource, backup.Name, backup.Namespace, id)
+	created, err := dynamicClient.Resource(backup.GroupVersionResource).Namespace(backup.Namespace).Create(context.Background(), obj, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error restoring %s %s in namespace %s: %v", backup.GroupVersionResource.Resource, backup.Name, backup.Namespace, err)
+	}
+
+	logger.Infof("Restored %s %s in namespace %s with uid %s", backup.GroupVersionResource.Resource, created.GetName(), created.GetNamespace(), created.GetUID())
+	return created, nil
+}
+
+// PrepareForRestore returns a copy of a backed up object without the fields assigned by the API server, the
+// finalizers remediation removed, or the owner references for which ownerExists reports the owner is gone.
+func PrepareForRestore(backup *Backup, ownerExists func(ref metav1.OwnerReference) (bool, error)) (*unstructured.Unstructured, error) {
+	obj := backup.Object.DeepCopy()
+	obj.SetResourceVersion("")
+	obj.SetUID("")
+	obj.SetGeneration(0)
+	obj.SetSelfLink("")
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+	obj.SetDeletionGracePeriodSeconds(nil)
+	obj.SetManagedFields(nil)
+	unstructured.RemoveNestedField(obj.Object, "status")
+
+	removed := make(map[string]bool, len(backup.RemovedFinalizers))
+	for _, finalizer := range backup.RemovedFinalizers {
+		removed[finalizer] = true
+	}
+	var finalizers []string
+	for _, finalizer := range obj.GetFinalizers() {
+		if removed[finalizer] {
+			logger.Infof("Not restoring finalizer %s removed by remediation", finalizer)
+			continue
+		}
+		finalizers = append(finalizers, finalizer)
+	}
+	obj.SetFinalizers(finalizers)
+
+	var ownerReferences []metav1.OwnerReference
+	for _, ref := range obj.GetOwnerReferences() {
+		exists, err := ownerExists(ref)
+		if err != nil {
+			return nil, fmt.Errorf("error checking owner %s %s: %v", ref.Kind, ref.Name, err)
+		}
+		if !exists {
+			logger.Warnf("Dropping the owner reference to %s %s, which no longer exists", ref.Kind, ref.Name)
+			continue
+		}
+		ownerReferences = append(ownerReferences, ref)
+	}
+	obj.SetOwnerReferences(ownerReferences)
+	return obj, nil
+}
+
+// ownerExists reports whether the owner an owner reference points to still exists with the same UID. Owners
+// are either cluster-scoped or in the namespace of their dependent. An owner whose kind is no longer served
+// cannot exist.
+func ownerExists(dynamicClient dynamic.Interface, mapper meta.RESTMapper, namespace string, ref metav1.OwnerReference) (bool, error) {
+	groupVersion, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return false, fmt.Errorf("error parsing apiVersion %s: %v", ref.APIVersion, err)
+	}
+	mapping, err := mapper.RESTMapping(schema.GroupKind{Group: groupVersion.Group, Kind: ref.Kind}, groupVersion.Version)
+	if meta.IsNoMatchError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error mapping kind %s: %v", ref.Kind, err)
+	}
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		namespace = ""
+	}
+
+	owner, err := dynamicClient.Resource(mapping.Resource).Namespace(namespace).Get(context.Background(), ref.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return owner.GetUID() == ref.UID, nil
+}
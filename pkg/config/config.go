@@ -6,18 +6,51 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/version"
 )
 
 // AppConfig structure for environment-based configurations.
 type AppConfig struct {
-	Debug        bool   `json:"debug"`
-	MetricsPort  int    `json:"metricsPort"`
-	Kubeconfig   string `json:"kubeconfig"`
-	DeleteAfter  int    `json:"deleteAfter"`
-	ScanInterval int    `json:"scanInterval"`
-	Version      bool   `json:"version"`
+	Debug                     bool                     `json:"debug"`
+	MetricsPort               int                      `json:"metricsPort"`
+	Kubeconfig                string                   `json:"kubeconfig"`
+	DeleteAfter               int                      `json:"deleteAfter"`
+	ScanInterval              int                      `json:"scanInterval"`
+	Mode                      string                   `json:"mode"`
+	PageSize                  int                      `json:"pageSize"`
+	ScanWorkers               int                      `json:"scanWorkers"`
+	QPS                       float64                  `json:"qps"`
+	Burst                     int                      `json:"burst"`
+	LabelsOfInterest          []string                 `json:"labelsOfInterest"`
+	AnnotationsOfInterest     []string                 `json:"annotationsOfInterest"`
+	StuckAfter                time.Duration            `json:"stuckAfter"`
+	StuckAfterOverrides       map[string]time.Duration `json:"stuckAfterOverrides"`
+	DryRun                    bool                     `json:"dryRun"`
+	PolicyFile                string                   `json:"policyFile"`
+	OwnerRegistryFile         string                   `json:"ownerRegistryFile"`
+	RemovableFinalizers       []string                 `json:"removableFinalizers"`
+	ProtectedFinalizers       []string                 `json:"protectedFinalizers"`
+	BackupBackend             string                   `json:"backupBackend"`
+	BackupDir                 string                   `json:"backupDir"`
+	BackupNamespace           string                   `json:"backupNamespace"`
+	BackupName                string                   `json:"backupName"`
+	BackupMaxBytes            int                      `json:"backupMaxBytes"`
+	RemediationsPerMinute     float64                  `json:"remediationsPerMinute"`
+	RemediationBurst          int                      `json:"remediationBurst"`
+	MaxRemediationsPerCycle   int                      `json:"maxRemediationsPerCycle"`
+	CircuitBreakerThreshold   int                      `json:"circuitBreakerThreshold"`
+	CircuitBreakerMaxIncrease int                      `json:"circuitBreakerMaxIncrease"`
+	AuditFile                 string                   `json:"auditFile"`
+	AuditEvents               bool                     `json:"auditEvents"`
+	RequireApproval           bool                     `json:"requireApproval"`
+	ApprovalExpiry            time.Duration            `json:"approvalExpiry"`
+	ApprovalPort              int                      `json:"approvalPort"`
+	ApprovalTLSCertFile       string                   `json:"approvalTLSCertFile"`
+	ApprovalTLSKeyFile        string                   `json:"approvalTLSKeyFile"`
+	Version                   bool                     `json:"version"`
 }
 
 // CFG is the global configuration instance populated by LoadConfiguration.
@@ -30,6 +63,37 @@ func LoadConfiguration() {
 	Kubeconfig := flag.String("kubeconfig", getEnvOrDefault("KUBECONFIG", ""), "Path to the kubeconfig file")
 	DeleteAfter := flag.Int("deleteAfter", parseEnvInt("DELETE_AFTER", 72), "Number of hours to wait before deleting stuck objects")
 	ScanInterval := flag.Int("scanInterval", parseEnvInt("SCAN_INTERVAL", 24), "Number of hours to wait between scans")
+	Mode := flag.String("mode", getEnvOrDefault("MODE", "scan"), "Detection mode: 'scan' for periodic scans or 'watch' for continuous informer-based detection")
+	PageSize := flag.Int("pageSize", parseEnvInt("PAGE_SIZE", 500), "Maximum number of objects to request per LIST page during a scan")
+	ScanWorkers := flag.Int("scanWorkers", parseEnvInt("SCAN_WORKERS", 4), "Number of resources to scan in parallel")
+	QPS := flag.Float64("qps", parseEnvFloat("QPS", 20), "Maximum queries per second to the Kubernetes API server")
+	Burst := flag.Int("burst", parseEnvInt("BURST", 40), "Maximum burst of queries to the Kubernetes API server")
+	LabelsOfInterest := flag.String("labelsOfInterest", getEnvOrDefault("LABELS_OF_INTEREST", "app,app.kubernetes.io/,helm.sh/chart"), "Comma-separated label keys, or key prefixes ending in '/', to report on stuck objects")
+	AnnotationsOfInterest := flag.String("annotationsOfInterest", getEnvOrDefault("ANNOTATIONS_OF_INTEREST", "meta.helm.sh/,deletion-inspector/"), "Comma-separated annotation keys, or key prefixes ending in '/', to report on stuck objects")
+	StuckAfter := flag.Duration("stuckAfter", parseEnvDuration("STUCK_AFTER", 5*time.Minute), "How long an object must have been deleting, after its grace period, before it counts as stuck")
+	StuckAfterOverrides := flag.String("stuckAfterOverrides", getEnvOrDefault("STUCK_AFTER_OVERRIDES", ""), "Comma-separated resource=duration overrides of stuckAfter, e.g. 'pods=10m,widgets.example.com=1h'")
+	DryRun := flag.Bool("dryRun", parseEnvBool("DRY_RUN", false), "Plan and validate remediation with server-side dry-run without changing anything")
+	PolicyFile := flag.String("policyFile", getEnvOrDefault("POLICY_FILE", ""), "Path to a YAML remediation policy; when unset every stuck object is force deleted after deleteAfter hours")
+	OwnerRegistryFile := flag.String("ownerRegistryFile", getEnvOrDefault("OWNER_REGISTRY_FILE", ""), "Path to a YAML file mapping finalizers to the workloads of the controllers that own them, in addition to the built-in owners")
+	RemovableFinalizers := flag.String("removableFinalizers", getEnvOrDefault("REMOVABLE_FINALIZERS", "*"), "Comma-separated finalizer globs remediation may remove; all other finalizers are left in place")
+	ProtectedFinalizers := flag.String("protectedFinalizers", getEnvOrDefault("PROTECTED_FINALIZERS", "kubernetes.io/pvc-protection,kubernetes.io/pv-protection"), "Comma-separated finalizer globs remediation never removes, even when allowed by removableFinalizers")
+	BackupBackend := flag.String("backupBackend", getEnvOrDefault("BACKUP_BACKEND", "secret"), "Where objects are backed up before remediation changes them: 'directory', 'configmap', 'secret' or 'none'")
+	BackupDir := flag.String("backupDir", getEnvOrDefault("BACKUP_DIR", "/var/lib/k8s-deletion-inspector/backups"), "Directory backups are written to with the directory backend")
+	BackupNamespace := flag.String("backupNamespace", getEnvOrDefault("BACKUP_NAMESPACE", getEnvOrDefault("POD_NAMESPACE", "default")), "Namespace of the ConfigMap or Secret backups are written to")
+	BackupName := flag.String("backupName", getEnvOrDefault("BACKUP_NAME", "k8s-deletion-inspector-backups"), "Name of the ConfigMap or Secret backups are written to")
+	BackupMaxBytes := flag.Int("backupMaxBytes", parseEnvInt("BACKUP_MAX_BYTES", 900000), "Maximum total size of the backups kept in the ConfigMap or Secret; the oldest are evicted first")
+	RemediationsPerMinute := flag.Float64("remediationsPerMinute", parseEnvFloat("REMEDIATIONS_PER_MINUTE", 30), "Maximum finalizer removals and force deletions per minute; 0 disables the rate limit")
+	RemediationBurst := flag.Int("remediationBurst", parseEnvInt("REMEDIATION_BURST", 5), "Maximum burst of finalizer removals and force deletions")
+	MaxRemediationsPerCycle := flag.Int("maxRemediationsPerCycle", parseEnvInt("MAX_REMEDIATIONS_PER_CYCLE", 100), "Maximum finalizer removals and force deletions per cleanup cycle; 0 disables the cap")
+	CircuitBreakerThreshold := flag.Int("circuitBreakerThreshold", parseEnvInt("CIRCUIT_BREAKER_THRESHOLD", 500), "Halt all remediation when more objects than this are eligible in a cycle; 0 disables the check")
+	CircuitBreakerMaxIncrease := flag.Int("circuitBreakerMaxIncrease", parseEnvInt("CIRCUIT_BREAKER_MAX_INCREASE", 100), "Halt all remediation when the number of eligible objects grows by more than this since the previous cycle; 0 disables the check")
+	AuditFile := flag.String("auditFile", getEnvOrDefault("AUDIT_FILE", "-"), "Append-only JSON-lines file recording every remediation action; '-' writes the records to stdout and 'none' disables them")
+	RequireApproval := flag.Bool("requireApproval", parseEnvBool("REQUIRE_APPROVAL", false), "Queue finalizer removals and force deletions for approval over HTTP instead of running them unattended")
+	ApprovalExpiry := flag.Duration("approvalExpiry", parseEnvDuration("APPROVAL_EXPIRY", 24*time.Hour), "How long an approval stays valid before the action must be approved again")
+	ApprovalPort := flag.Int("approvalPort", parseEnvInt("APPROVAL_PORT", 9443), "Port of the authenticated approval API, separate from the metrics server")
+	ApprovalTLSCertFile := flag.String("approvalTLSCertFile", getEnvOrDefault("APPROVAL_TLS_CERT_FILE", ""), "TLS certificate of the approval API; when unset it is served over plain HTTP")
+	ApprovalTLSKeyFile := flag.String("approvalTLSKeyFile", getEnvOrDefault("APPROVAL_TLS_KEY_FILE", ""), "TLS private key of the approval API")
+	AuditEvents := flag.Bool("auditEvents", parseEnvBool("AUDIT_EVENTS", true), "Emit Kubernetes Events on objects, and their namespaces, whose finalizers were removed or that were force deleted")
 	showVersion := flag.Bool("version", false, "Show version and exit")
 
 	flag.Parse()
@@ -39,6 +103,37 @@ func LoadConfiguration() {
 	CFG.Kubeconfig = *Kubeconfig
 	CFG.DeleteAfter = *DeleteAfter
 	CFG.ScanInterval = *ScanInterval
+	CFG.Mode = *Mode
+	CFG.PageSize = *PageSize
+	CFG.ScanWorkers = *ScanWorkers
+	CFG.QPS = *QPS
+	CFG.Burst = *Burst
+	CFG.LabelsOfInterest = splitList(*LabelsOfInterest)
+	CFG.AnnotationsOfInterest = splitList(*AnnotationsOfInterest)
+	CFG.StuckAfter = *StuckAfter
+	CFG.StuckAfterOverrides = parseDurationMap(*StuckAfterOverrides)
+	CFG.DryRun = *DryRun
+	CFG.PolicyFile = *PolicyFile
+	CFG.OwnerRegistryFile = *OwnerRegistryFile
+	CFG.RemovableFinalizers = splitList(*RemovableFinalizers)
+	CFG.ProtectedFinalizers = splitList(*ProtectedFinalizers)
+	CFG.BackupBackend = *BackupBackend
+	CFG.BackupDir = *BackupDir
+	CFG.BackupNamespace = *BackupNamespace
+	CFG.BackupName = *BackupName
+	CFG.BackupMaxBytes = *BackupMaxBytes
+	CFG.RemediationsPerMinute = *RemediationsPerMinute
+	CFG.RemediationBurst = *RemediationBurst
+	CFG.MaxRemediationsPerCycle = *MaxRemediationsPerCycle
+	CFG.CircuitBreakerThreshold = *CircuitBreakerThreshold
+	CFG.CircuitBreakerMaxIncrease = *CircuitBreakerMaxIncrease
+	CFG.AuditFile = *AuditFile
+	CFG.AuditEvents = *AuditEvents
+	CFG.RequireApproval = *RequireApproval
+	CFG.ApprovalExpiry = *ApprovalExpiry
+	CFG.ApprovalPort = *ApprovalPort
+	CFG.ApprovalTLSCertFile = *ApprovalTLSCertFile
+	CFG.ApprovalTLSKeyFile = *ApprovalTLSKeyFile
 	CFG.Version = *showVersion
 
 	if CFG.Version {
@@ -47,18 +142,49 @@ func LoadConfiguration() {
 	}
 }
 
-// getEnvOrDefault returns the value of the environment variable with the given key or the default value if the key is not set.
+// splitList splits a comma-separated value into its trimmed, non-empty elements.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseDurationMap parses a comma-separated list of key=duration pairs, skipping entries that cannot be parsed.
+func parseDurationMap(value string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, item := range splitList(value) {
+		key, rawDuration, found := strings.Cut(item, "=")
+		if !found {
+			log.Printf("Error parsing %q: expected key=duration. Ignoring entry", item)
+			continue
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(rawDuration))
+		if err != nil {
+			log.Printf("Error parsing duration for %s: %v. Ignoring entry", key, err)
+			continue
+		}
+		durations[strings.TrimSpace(key)] = duration
+	}
+	return durations
+}
+
+// getEnvOrDefault returns the value of the environment variable with the given key or the default value if the key is not set or empty.
+// Chart values rendered as "" therefore keep their defaults.
 func getEnvOrDefault(key, defaultValue string) string {
-	if value, exists := os.LookupEnv(key); exists {
+	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-// parseEnvInt parses the environment variable with the given key and returns its integer representation or the default value if the key is not set.
+// parseEnvInt parses the environment variable with the given key and returns its integer representation or the default value if the key is not set or empty.
 func parseEnvInt(key string, defaultValue int) int {
-	value, exists := os.LookupEnv(key)
-	if !exists {
+	value := os.Getenv(key)
+	if value == "" {
 		return defaultValue
 	}
 	intValue, err := strconv.Atoi(value)
@@ -69,10 +195,38 @@ func parseEnvInt(key string, defaultValue int) int {
 	return intValue
 }
 
-// parseEnvBool parses the environment variable with the given key and returns its boolean representation or the default value if the key is not set.
+// parseEnvFloat parses the environment variable with the given key and returns its float representation or the default value if the key is not set or empty.
+func parseEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Error parsing %s as float: %v. Using default value: %g", key, err, defaultValue)
+		return defaultValue
+	}
+	return floatValue
+}
+
+// parseEnvDuration parses the environment variable with the given key and returns its duration representation or the default value if the key is not set or empty.
+func parseEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	durationValue, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Error parsing %s as duration: %v. Using default value: %s", key, err, defaultValue)
+		return defaultValue
+	}
+	return durationValue
+}
+
+// parseEnvBool parses the environment variable with the given key and returns its boolean representation or the default value if the key is not set or empty.
 func parseEnvBool(key string, defaultValue bool) bool {
-	value, exists := os.LookupEnv(key)
-	if !exists {
+	value := os.Getenv(key)
+	if value == "" {
 		return defaultValue
 	}
 	boolValue, err := strconv.ParseBool(value)
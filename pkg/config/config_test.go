@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLoadConfiguration(t *testing.T) {
@@ -38,4 +39,44 @@ func TestGetEnvOrDefault(t *testing.T) {
 	if value != expectedValue {
 		t.Errorf("Expected '%s', got '%s'", expectedValue, value)
 	}
+
+	os.Setenv(key, "")
+	value = getEnvOrDefault(key, defaultValue)
+	if value != defaultValue {
+		t.Errorf("Expected an empty value to fall back to '%s', got '%s'", defaultValue, value)
+	}
+}
+
+func TestParseEnvFloat(t *testing.T) {
+	key := "TEST_FLOAT_KEY"
+
+	value := parseEnvFloat(key, 1.5)
+	if value != 1.5 {
+		t.Errorf("Expected 1.5, got %g", value)
+	}
+
+	os.Setenv(key, "12.5")
+	value = parseEnvFloat(key, 1.5)
+	if value != 12.5 {
+		t.Errorf("Expected 12.5, got %g", value)
+	}
+
+	os.Setenv(key, "not-a-float")
+	value = parseEnvFloat(key, 1.5)
+	if value != 1.5 {
+		t.Errorf("Expected 1.5, got %g", value)
+	}
+}
+
+func TestParseDurationMap(t *testing.T) {
+	durations := parseDurationMap("pods=10m, widgets.example.com=1h,invalid,bad=soon")
+	if len(durations) != 2 {
+		t.Fatalf("Expected 2 durations, got %v", durations)
+	}
+	if durations["pods"] != 10*time.Minute {
+		t.Errorf("Expected pods to be 10m, got %s", durations["pods"])
+	}
+	if durations["widgets.example.com"] != time.Hour {
+		t.Errorf("Expected widgets.example.com to be 1h, got %s", durations["widgets.example.com"])
+	}
 }
@@ -0,0 +1,7 @@
+package k8s
+
+// Unexported functions exposed to the external test package.
+var (
+	RemoveFinalizersWithClient = removeFinalizers
+	ForceDeleteWithClient      = forceDelete
+)
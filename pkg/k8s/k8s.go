@@ -2,20 +2,28 @@ package k8s
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	v1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/flowcontrol"
+	"k8s.io/client-go/util/retry"
 )
 
 var logger = logging.SetupLogging()
@@ -29,7 +37,9 @@ type ClientsetInterface interface {
 // ConnectToCluster connects to the Kubernetes cluster using the provided kubeconfig file.
 // If the environment variables KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are set,
 // it assumes the application is running inside a Kubernetes cluster and uses the in-cluster config.
-func ConnectToCluster(kubeconfig string) (*kubernetes.Clientset, *rest.Config, error) {
+// The returned config is rate limited on the client side to qps queries per second with the given burst, and
+// every client built from it shares that limit.
+func ConnectToCluster(kubeconfig string, qps float32, burst int) (*kubernetes.Clientset, *rest.Config, error) {
 	logger.Debugln("Connecting to Kubernetes cluster...")
 
 	// Check if a kubeconfig file is provided.
@@ -55,6 +65,9 @@ func ConnectToCluster(kubeconfig string) (*kubernetes.Clientset, *rest.Config, e
 
 	// Otherwise, it assumes that the application is running outside a Kubernetes cluster and uses the provided kubeconfig file.
 	logger.Debugln("Application is running outside a Kubernetes cluster...")
+
+	SetRateLimit(config, qps, burst)
+
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		logger.Errorf("Error creating clientset: %v", err)
@@ -65,6 +78,17 @@ func ConnectToCluster(kubeconfig string) (*kubernetes.Clientset, *rest.Config, e
 	return clientset, config, nil
 }
 
+// SetRateLimit sets a single client-side rate limiter of qps queries per second with the given burst on a config.
+// Clients built from a config with only QPS and Burst set each create their own token bucket, so the total rate
+// would grow with the number of clients; a shared RateLimiter keeps every clientset, dynamic and metadata client
+// derived from the config within one budget.
+func SetRateLimit(config *rest.Config, qps float32, burst int) {
+	logger.Debugf("Setting client rate limit to %.2f QPS with a burst of %d...", qps, burst)
+	config.QPS = qps
+	config.Burst = burst
+	config.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(qps, burst)
+}
+
 // VerifyAccessToCluster verifies if the application has access to the Kubernetes cluster
 // by attempting to list the nodes in the cluster.
 func VerifyAccessToCluster(clientset ClientsetInterface) error {
@@ -108,13 +132,73 @@ func GetNamespaces(clientset ClientsetInterface) ([]string, error) {
 	return namespaces, nil
 }
 
+// GetTerminatingNamespaces retrieves the namespaces in the Kubernetes cluster that are in the Terminating phase.
+func GetTerminatingNamespaces(clientset ClientsetInterface) ([]corev1.Namespace, error) {
+	logger.Debugln("Fetching terminating namespaces...")
+
+	namespaceList, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		logger.Errorf("Error fetching namespaces: %v", err)
+		return nil, err
+	}
+
+	namespaces := make([]corev1.Namespace, 0)
+	for _, namespace := range namespaceList.Items {
+		if namespace.Status.Phase == corev1.NamespaceTerminating || namespace.GetDeletionTimestamp() != nil {
+			logger.Debugf("Namespace %s is terminating", namespace.GetName())
+			namespaces = append(namespaces, namespace)
+		}
+	}
+
+	logger.Debugf("Found %d terminating namespaces", len(namespaces))
+	return namespaces, nil
+}
+
+// GetNamespaceMetadata returns the metadata, including labels and annotations, of every namespace keyed by namespace name.
+func GetNamespaceMetadata(clientset ClientsetInterface) (map[string]metav1.ObjectMeta, error) {
+	logger.Debugln("Fetching namespace metadata...")
+
+	namespaceList, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		logger.Errorf("Error fetching namespaces: %v", err)
+		return nil, err
+	}
+
+	namespaces := make(map[string]metav1.ObjectMeta, len(namespaceList.Items))
+	for _, namespace := range namespaceList.Items {
+		namespaces[namespace.GetName()] = namespace.ObjectMeta
+	}
+	return namespaces, nil
+}
+
+// serverPreferredResources returns the preferred resources of every API group. When some groups cannot be
+// discovered, for example because an aggregated API is down, the resources of the groups that did resolve
+// are returned together with a *discovery.ErrGroupDiscoveryFailed naming the failed groups.
+func serverPreferredResources(clientset ClientsetInterface) ([]*metav1.APIResourceList, error) {
+	apiResourceList, err := clientset.Discovery().ServerPreferredResources()
+	if discovery.IsGroupDiscoveryFailedError(err) {
+		logger.Warnf("Discovery failed for some API groups, continuing with the groups that resolved: %v", err)
+	}
+	return apiResourceList, err
+}
+
+// FailedGroups returns the API groups that failed discovery when err is a *discovery.ErrGroupDiscoveryFailed,
+// or nil for any other error.
+func FailedGroups(err error) map[schema.GroupVersion]error {
+	if groupErr, ok := err.(*discovery.ErrGroupDiscoveryFailed); ok {
+		return groupErr.Groups
+	}
+	return nil
+}
+
 // GetNamespacedObjects retrieves the list of namespaced objects available in the cluster.
+// When some API groups fail discovery, the resources of the others are returned with the discovery error.
 func GetNamespacedObjects(clientset ClientsetInterface) ([]schema.GroupVersionResource, error) {
 	logger.Debugln("Fetching namespaced API resources...")
 
 	// List all namespaced API resources in the cluster.
-	apiResourceList, err := clientset.Discovery().ServerPreferredResources()
-	if err != nil {
+	apiResourceList, err := serverPreferredResources(clientset)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
 		logger.Errorf("Error fetching namespaced API resources: %v", err)
 		return nil, err
 	}
@@ -142,41 +226,107 @@ func GetNamespacedObjects(clientset ClientsetInterface) ([]schema.GroupVersionRe
 	}
 
 	logger.Debugln("Successfully fetched namespaced API resources...")
-	return objects, nil
+	return objects, err
 }
 
-// GetNamespaceObjects retrieves the objects in a namespace for a given resource.
-func GetNamespaceObjects(restConfig *rest.Config, ns string, resource schema.GroupVersionResource) ([]string, error) {
-	logger.Debugf("Fetching objects for resource %s in namespace %s with GroupVersion %s", resource.Resource, ns, resource.GroupVersion())
+// GetClusterScopedObjects retrieves the list of cluster-scoped objects available in the cluster that can be listed.
+// When some API groups fail discovery, the resources of the others are returned with the discovery error.
+func GetClusterScopedObjects(clientset ClientsetInterface) ([]schema.GroupVersionResource, error) {
+	logger.Debugln("Fetching cluster-scoped API resources...")
 
-	// Create a dynamic client to interact with the Kubernetes API.
-	logger.Debugln("Creating dynamic client...")
-	dynamicClient, err := dynamic.NewForConfig(restConfig)
-	if err != nil {
-		logger.Errorf("Error creating dynamic client: %v", err)
+	// List all API resources in the cluster.
+	apiResourceList, err := serverPreferredResources(clientset)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		logger.Errorf("Error fetching cluster-scoped API resources: %v", err)
 		return nil, err
 	}
 
-	// List all objects in the namespace for the given resource.
-	logger.Debugln("Listing objects in the namespace...")
-	resourceClient := dynamicClient.Resource(resource).Namespace(ns)
-	objectList, err := resourceClient.List(context.Background(), metav1.ListOptions{})
-	if err != nil {
-		logger.Errorf("Error fetching objects for resource %s in namespace %s: %v", resource.Resource, ns, err)
+	// Extract the listable cluster-scoped objects from the API resources.
+	listable := discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list"}}, apiResourceList)
+	objects := make([]schema.GroupVersionResource, 0)
+	for _, apiResources := range listable {
+		if ShouldIgnoreGroup(apiResources.GroupVersion) {
+			logger.Debugf("Ignoring group version: %s", apiResources.GroupVersion)
+			continue
+		}
+		gv, parseErr := schema.ParseGroupVersion(apiResources.GroupVersion)
+		if parseErr != nil {
+			logger.Errorf("Error parsing group version %s: %v", apiResources.GroupVersion, parseErr)
+			continue
+		}
+		for _, apiResource := range apiResources.APIResources {
+			if !apiResource.Namespaced {
+				logger.Debugf("Found cluster-scoped API resource: %s", apiResource.Name)
+				objects = append(objects, gv.WithResource(apiResource.Name))
+			}
+		}
+	}
+
+	logger.Debugln("Successfully fetched cluster-scoped API resources...")
+	return objects, err
+}
+
+// GetWatchableResources retrieves the namespaced and cluster-scoped resources in the cluster that support both list and watch.
+// When some API groups fail discovery, the resources of the others are returned with the discovery error.
+func GetWatchableResources(clientset ClientsetInterface) ([]schema.GroupVersionResource, error) {
+	logger.Debugln("Fetching watchable API resources...")
+
+	apiResourceList, err := serverPreferredResources(clientset)
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		logger.Errorf("Error fetching API resources: %v", err)
 		return nil, err
 	}
 
-	if len(objectList.Items) == 0 {
-		logger.Warnf("No objects found for resource %s in namespace %s", resource.Resource, ns)
-	} else {
-		logger.Debugf("Found %d objects for resource %s in namespace %s: %v", len(objectList.Items), resource.Resource, ns, objectList.Items)
+	watchable := discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "watch"}}, apiResourceList)
+	objects := make([]schema.GroupVersionResource, 0)
+	for _, apiResources := range watchable {
+		if ShouldIgnoreGroup(apiResources.GroupVersion) {
+			logger.Debugf("Ignoring group version: %s", apiResources.GroupVersion)
+			continue
+		}
+		gv, parseErr := schema.ParseGroupVersion(apiResources.GroupVersion)
+		if parseErr != nil {
+			logger.Errorf("Error parsing group version %s: %v", apiResources.GroupVersion, parseErr)
+			continue
+		}
+		for _, apiResource := range apiResources.APIResources {
+			logger.Debugf("Found watchable API resource: %s", apiResource.Name)
+			objects = append(objects, gv.WithResource(apiResource.Name))
+		}
 	}
 
-	objects := make([]string, len(objectList.Items))
-	for i, object := range objectList.Items {
-		objects[i] = object.GetName()
+	logger.Debugf("Successfully fetched %d watchable API resources...", len(objects))
+	return objects, err
+}
+
+// GetNamespaceObjects retrieves the metadata of the objects in a namespace for a given resource.
+// Passing metav1.NamespaceAll as ns lists the resource across the whole cluster.
+// Only ObjectMeta is fetched, and the results are paginated using pageSize so large resources
+// never have to be held in a single response.
+func GetNamespaceObjects(metadataClient metadata.Interface, ns string, resource schema.GroupVersionResource, pageSize int64) ([]metav1.PartialObjectMetadata, error) {
+	logger.Debugf("Fetching objects for resource %s in namespace %s with GroupVersion %s", resource.Resource, ns, resource.GroupVersion())
+
+	// List the metadata of all objects in the namespace for the given resource, one page at a time.
+	logger.Debugln("Listing objects in the namespace...")
+	resourceClient := metadataClient.Resource(resource).Namespace(ns)
+	objects := make([]metav1.PartialObjectMetadata, 0)
+	listOptions := metav1.ListOptions{Limit: pageSize}
+	for {
+		objectList, err := resourceClient.List(context.Background(), listOptions)
+		if err != nil {
+			logger.Errorf("Error fetching objects for resource %s in namespace %s: %v", resource.Resource, ns, err)
+			return nil, err
+		}
+		objects = append(objects, objectList.Items...)
+
+		if objectList.GetContinue() == "" {
+			break
+		}
+		listOptions.Continue = objectList.GetContinue()
 	}
-	logger.Debugf("Object names for resource %s in namespace %s: %v", resource.Resource, ns, objects)
+
+	logger.Debugf("Found %d objects for resource %s in namespace %q", len(objects), resource.Resource, ns)
+
 	return objects, nil
 }
 
@@ -190,28 +340,31 @@ func GetAPIVersionForResource(clientset ClientsetInterface, resource schema.Grou
 	return apiVersion, nil
 }
 
-// IsObjectDeleted checks if an object is marked for deletion and returns the deletion timestamp if it exists.
-func IsObjectDeleted(restConfig *rest.Config, ns string, resource schema.GroupVersionResource, name string) (bool, time.Time, error) {
-	dynamicClient, err := dynamic.NewForConfig(restConfig)
+// GetKindForResource returns the kind of a resource from discovery.
+func GetKindForResource(clientset ClientsetInterface, resource schema.GroupVersionResource) (string, error) {
+	resourceList, err := clientset.Discovery().ServerResourcesForGroupVersion(resource.GroupVersion().String())
 	if err != nil {
-		return false, time.Time{}, fmt.Errorf("error creating dynamic client: %v", err)
+		return "", fmt.Errorf("error fetching resources for group version %s: %v", resource.GroupVersion(), err)
 	}
-
-	res := dynamicClient.Resource(resource).Namespace(ns)
-	obj, err := res.Get(context.Background(), name, metav1.GetOptions{})
-	if err != nil {
-		logger.Errorf("Error fetching object %s in namespace %s: %v", name, ns, err)
-		return false, time.Time{}, err
+	for _, apiResource := range resourceList.APIResources {
+		if apiResource.Name == resource.Resource {
+			return apiResource.Kind, nil
+		}
 	}
+	return "", fmt.Errorf("resource %s not found in group version %s", resource.Resource, resource.GroupVersion())
+}
 
+// IsObjectDeleted checks if an object is marked for deletion and returns the deletion timestamp if it exists.
+// The check is made against the object as returned by a LIST, so no additional request is sent to the API server.
+func IsObjectDeleted(obj metav1.Object) (bool, time.Time) {
 	deletionTimestamp := obj.GetDeletionTimestamp()
 	if deletionTimestamp != nil {
-		logger.Infof("Object %s in namespace %s is marked for deletion", name, ns)
-		return true, deletionTimestamp.Time, nil
+		logger.Debugf("Object %s in namespace %s is marked for deletion with finalizers %v", obj.GetName(), obj.GetNamespace(), obj.GetFinalizers())
+		return true, deletionTimestamp.Time
 	}
 
-	logger.Infof("Object %s in namespace %s is not marked for deletion", name, ns)
-	return false, time.Time{}, nil
+	logger.Debugf("Object %s in namespace %s is not marked for deletion", obj.GetName(), obj.GetNamespace())
+	return false, time.Time{}
 }
 
 // ShouldIgnoreGroup determines if a group should be ignored during discovery.
@@ -219,32 +372,209 @@ func ShouldIgnoreGroup(groupVersion string) bool {
 	return strings.Contains(groupVersion, "metrics.k8s.io")
 }
 
+// GetObject fetches the full object, including spec and status, of a resource.
+func GetObject(dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, name string) (*unstructured.Unstructured, error) {
+	return dynamicClient.Resource(resource).Namespace(ns).Get(context.Background(), name, metav1.GetOptions{})
+}
+
+// RemediationResult describes what a remediation changed on an object.
+type RemediationResult struct {
+	RemovedFinalizers   []string `json:"removedFinalizers,omitempty"`
+	RemainingFinalizers []string `json:"remainingFinalizers,omitempty"`
+	// Deleted reports whether a delete request was accepted for the object.
+	Deleted bool `json:"deleted"`
+	// AlreadyGone reports whether the object no longer existed, which counts as success.
+	AlreadyGone bool `json:"alreadyGone"`
+	// Attempts is the number of times the finalizer patch was computed, including retries after conflicts.
+	Attempts int `json:"attempts"`
+}
+
 // ForceDeleteOldResource forcefully deletes a specific resource that has been in the deletion state for more than DeleteAfter hours.
-func ForceDeleteOldResource(restConfig *rest.Config, ns string, resource schema.GroupVersionResource, name string) error {
-	logger.Infof("Force deleting old resource %s for resource %s in namespace %s", name, resource.Resource, ns)
+// Only the finalizers selected by shouldRemove are removed before the delete is issued; the rest are left in place.
+func ForceDeleteOldResource(dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, name string, shouldRemove func(finalizer string) bool, dryRun bool) (RemediationResult, error) {
+	logger.Infof("Force deleting old resource %s for resource %s in namespace %s (dry-run: %t)", name, resource.Resource, ns, dryRun)
+	return forceDelete(dynamicClient.Resource(resource).Namespace(ns), name, shouldRemove, dryRun)
+}
 
-	dynamicClient, err := dynamic.NewForConfig(restConfig)
+// forceDelete removes the finalizers selected by shouldRemove and deletes the object. An object that is
+// already gone counts as success.
+func forceDelete(resourceClient dynamic.ResourceInterface, name string, shouldRemove func(finalizer string) bool, dryRun bool) (RemediationResult, error) {
+	result, err := removeFinalizers(resourceClient, name, shouldRemove, dryRun)
 	if err != nil {
-		return fmt.Errorf("error creating dynamic client: %v", err)
+		return result, fmt.Errorf("error removing finalizers for object %s: %v", name, err)
+	}
+	if result.AlreadyGone {
+		return result, nil
 	}
 
-	resourceClient := dynamicClient.Resource(resource).Namespace(ns)
-	obj, err := resourceClient.Get(context.Background(), name, metav1.GetOptions{})
+	err = resourceClient.Delete(context.Background(), name, metav1.DeleteOptions{DryRun: dryRunOption(dryRun)})
+	if errors.IsNotFound(err) {
+		logger.Infof("Object %s was already gone before it could be deleted", name)
+		result.AlreadyGone = true
+		return result, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error fetching object %s in namespace %s: %v", name, ns, err)
+		return result, fmt.Errorf("error deleting object %s: %v", name, err)
 	}
 
-	obj.SetFinalizers(nil)
-	_, err = resourceClient.Update(context.Background(), obj, metav1.UpdateOptions{})
+	result.Deleted = true
+	logger.Infof("Successfully deleted object %s (dry-run: %t)", name, dryRun)
+	return result, nil
+}
+
+// RemoveFinalizers removes the finalizers selected by shouldRemove from an object, leaving any others in place.
+func RemoveFinalizers(dynamicClient dynamic.Interface, ns string, resource schema.GroupVersionResource, name string, shouldRemove func(finalizer string) bool, dryRun bool) (RemediationResult, error) {
+	logger.Infof("Removing finalizers from %s for resource %s in namespace %s (dry-run: %t)", name, resource.Resource, ns, dryRun)
+
+	result, err := removeFinalizers(dynamicClient.Resource(resource).Namespace(ns), name, shouldRemove, dryRun)
 	if err != nil {
-		return fmt.Errorf("error removing finalizers for object %s in namespace %s: %v", name, ns, err)
+		return result, fmt.Errorf("error removing finalizers for object %s in namespace %s: %v", name, ns, err)
 	}
+	return result, nil
+}
+
+// removeFinalizers removes the finalizers selected by shouldRemove with a JSON patch. The patch tests the
+// resourceVersion that was read and each finalizer entry it removes, so any concurrent change to the object fails
+// the patch rather than removing the wrong entries; the object is then fetched again and the patch recomputed.
+// An object that is already gone counts as success.
+func removeFinalizers(resourceClient dynamic.ResourceInterface, name string, shouldRemove func(finalizer string) bool, dryRun bool) (RemediationResult, error) {
+	var result RemediationResult
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result = RemediationResult{Attempts: result.Attempts + 1}
 
-	err = resourceClient.Delete(context.Background(), name, metav1.DeleteOptions{})
+		obj, err := resourceClient.Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		patch := []map[string]interface{}{
+			{"op": "test", "path": "/metadata/resourceVersion", "value": obj.GetResourceVersion()},
+		}
+		finalizers := obj.GetFinalizers()
+		// Remove from the end so the indexes of the remaining finalizers stay valid.
+		for i := len(finalizers) - 1; i >= 0; i-- {
+			if !shouldRemove(finalizers[i]) {
+				result.RemainingFinalizers = append([]string{finalizers[i]}, result.RemainingFinalizers...)
+				continue
+			}
+			path := fmt.Sprintf("/metadata/finalizers/%d", i)
+			patch = append(patch,
+				map[string]interface{}{"op": "test", "path": path, "value": finalizers[i]},
+				map[string]interface{}{"op": "remove", "path": path},
+			)
+			result.RemovedFinalizers = append(result.RemovedFinalizers, finalizers[i])
+		}
+
+		if len(result.RemovedFinalizers) == 0 {
+			logger.Infof("Object %s has no removable finalizers among %v", name, finalizers)
+			return nil
+		}
+
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return fmt.Errorf("error encoding patch: %v", err)
+		}
+		_, err = resourceClient.Patch(context.Background(), name, types.JSONPatchType, data, metav1.PatchOptions{DryRun: dryRunOption(dryRun)})
+		if errors.IsInvalid(err) && objectChanged(resourceClient, obj) {
+			err = errors.NewConflict(schema.GroupResource{Group: obj.GroupVersionKind().Group, Resource: obj.GetKind()}, name, err)
+		}
+		if errors.IsConflict(err) {
+			logger.Infof("Object %s changed while removing finalizers, retrying: %v", name, err)
+		}
+		return err
+	})
+
+	if errors.IsNotFound(err) {
+		logger.Infof("Object %s is already gone", name)
+		return RemediationResult{AlreadyGone: true, Attempts: result.Attempts}, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("error patching object after %d attempts: %v", result.Attempts, err)
+	}
+
+	if len(result.RemovedFinalizers) > 0 {
+		logger.Infof("Removed finalizers %v from object %s, keeping %v (dry-run: %t)", result.RemovedFinalizers, name, result.RemainingFinalizers, dryRun)
+	}
+	return result, nil
+}
+
+// FinalizeNamespace clears the spec finalizers selected by shouldRemove from a Terminating namespace through
+// its finalize subresource, which is the only way to change them. The update is retried when the namespace
+// changed since it was read. A namespace that is already gone counts as success.
+func FinalizeNamespace(clientset ClientsetInterface, name string, shouldRemove func(finalizer string) bool, dryRun bool) (RemediationResult, error) {
+	logger.Infof("Finalizing namespace %s (dry-run: %t)", name, dryRun)
+
+	var result RemediationResult
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		result = RemediationResult{Attempts: result.Attempts + 1}
+
+		namespace, err := clientset.CoreV1().Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if namespace.GetDeletionTimestamp() == nil {
+			return fmt.Errorf("namespace %s is not being deleted", name)
+		}
+
+		var finalizers []corev1.FinalizerName
+		for _, finalizer := range namespace.Spec.Finalizers {
+			if shouldRemove(string(finalizer)) {
+				result.RemovedFinalizers = append(result.RemovedFinalizers, string(finalizer))
+				continue
+			}
+			finalizers = append(finalizers, finalizer)
+			result.RemainingFinalizers = append(result.RemainingFinalizers, string(finalizer))
+		}
+		if len(result.RemovedFinalizers) == 0 {
+			logger.Infof("Namespace %s has no removable spec finalizers among %v", name, namespace.Spec.Finalizers)
+			return nil
+		}
+
+		namespace.Spec.Finalizers = finalizers
+		_, err = clientset.CoreV1().Namespaces().Finalize(context.Background(), namespace, metav1.UpdateOptions{DryRun: dryRunOption(dryRun)})
+		if errors.IsConflict(err) {
+			logger.Infof("Namespace %s changed while finalizing, retrying: %v", name, err)
+		}
+		return err
+	})
+
+	if errors.IsNotFound(err) {
+		logger.Infof("Namespace %s is already gone", name)
+		return RemediationResult{AlreadyGone: true, Attempts: result.Attempts}, nil
+	}
 	if err != nil {
-		return fmt.Errorf("error deleting object %s in namespace %s: %v", name, ns, err)
+		return result, fmt.Errorf("error finalizing namespace %s after %d attempts: %v", name, result.Attempts, err)
 	}
 
-	logger.Infof("Successfully removed finalizers and deleted object %s in namespace %s", name, ns)
+	if len(result.RemovedFinalizers) > 0 {
+		logger.Infof("Removed spec finalizers %v from namespace %s, keeping %v (dry-run: %t)", result.RemovedFinalizers, name, result.RemainingFinalizers, dryRun)
+	}
+	return result, nil
+}
+
+// objectChanged reports whether an object's resourceVersion or finalizers differ from the copy that was read, or
+// whether it is gone. The apiserver rejects a failed test operation with the same 422 Unprocessable Entity as an
+// invalid patch, so the object is read again to tell a concurrent change, worth retrying, from a patch that
+// would fail again.
+func objectChanged(resourceClient dynamic.ResourceInterface, read *unstructured.Unstructured) bool {
+	current, err := resourceClient.Get(context.Background(), read.GetName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		logger.Errorf("Error fetching object %s to check for concurrent changes: %v", read.GetName(), err)
+		return false
+	}
+	if current.GetResourceVersion() != read.GetResourceVersion() {
+		return true
+	}
+	return strings.Join(current.GetFinalizers(), ",") != strings.Join(read.GetFinalizers(), ",")
+}
+
+// dryRunOption returns the DryRun option for a write request.
+func dryRunOption(dryRun bool) []string {
+	if dryRun {
+		return []string{metav1.DryRunAll}
+	}
 	return nil
 }
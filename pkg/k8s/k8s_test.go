@@ -1,28 +1,67 @@
 package k8s_test
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
 	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
 	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestConnectToCluster(t *testing.T) {
 	os.Setenv("KUBERNETES_SERVICE_HOST", "dummy-host")
 	os.Setenv("KUBERNETES_SERVICE_PORT", "dummy-port")
-	_, _, err := k8s.ConnectToCluster("")
+	_, _, err := k8s.ConnectToCluster("", 20, 40)
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
 }
 
+func TestSetRateLimitSharesLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"major":"1","minor":"30"}`)
+	}))
+	defer server.Close()
+
+	config := &rest.Config{Host: server.URL}
+	k8s.SetRateLimit(config, 0.001, 2)
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Each client takes one token, which only exhausts the burst of 2 if both draw from the same limiter.
+	_, _ = clientset.Discovery().ServerVersion()
+	_, _ = dynamicClient.Resource(widgetsResource).Namespace("default").Get(context.Background(), "widget", metav1.GetOptions{})
+	if config.RateLimiter.TryAccept() {
+		t.Error("Expected the clients to share the config's rate limiter")
+	}
+}
+
 func TestVerifyAccessToCluster(t *testing.T) {
 	clientset := kubernetesfake.NewSimpleClientset()
 	err := k8s.VerifyAccessToCluster(clientset)
@@ -55,19 +94,34 @@ func TestGetNamespacedObjects(t *testing.T) {
 	}
 }
 
+func TestGetClusterScopedObjects(t *testing.T) {
+	clientset := kubernetesfake.NewSimpleClientset()
+	_, err := k8s.GetClusterScopedObjects(clientset)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
 func TestGetNamespaceObjects(t *testing.T) {
-	restConfig := &rest.Config{}
 	ns := "default"
 	resource := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
 
-	// Mock dynamic client
-	scheme := runtime.NewScheme()
-	dynamicClient := dynamicfake.NewSimpleDynamicClient(scheme)
-	_ = dynamicClient // Avoid unused variable error
-	_, err := k8s.GetNamespaceObjects(restConfig, ns, resource)
+	// Mock metadata client
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	metadataClient := metadatafake.NewSimpleMetadataClient(scheme,
+		newPodMetadata(ns, "test-pod"),
+		newPodMetadata(ns, "other-pod"),
+	)
+	objects, err := k8s.GetNamespaceObjects(metadataClient, ns, resource, 1)
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
+	if len(objects) != 2 {
+		t.Errorf("Expected 2 objects, got %d", len(objects))
+	}
 }
 
 func TestGetAPIVersionForResource(t *testing.T) {
@@ -81,18 +135,22 @@ func TestGetAPIVersionForResource(t *testing.T) {
 }
 
 func TestIsObjectDeleted(t *testing.T) {
-	restConfig := &rest.Config{}
-	ns := "default"
-	resource := schema.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
-	name := "test-pod"
+	obj := newPodMetadata("default", "test-pod")
 
-	isDeleted, _, err := k8s.IsObjectDeleted(restConfig, ns, resource, name)
-	if err == nil {
-		t.Errorf("Expected error, got nil")
-	}
+	isDeleted, _ := k8s.IsObjectDeleted(obj)
 	if isDeleted {
 		t.Errorf("Expected object to not be deleted, got deleted")
 	}
+
+	deletionTimestamp := metav1.NewTime(time.Now().Truncate(time.Second))
+	obj.SetDeletionTimestamp(&deletionTimestamp)
+	isDeleted, timestamp := k8s.IsObjectDeleted(obj)
+	if !isDeleted {
+		t.Errorf("Expected object to be deleted, got not deleted")
+	}
+	if !timestamp.Equal(deletionTimestamp.Time) {
+		t.Errorf("Expected deletion timestamp %v, got %v", deletionTimestamp.Time, timestamp)
+	}
 }
 
 func TestShouldIgnoreGroup(t *testing.T) {
@@ -102,3 +160,190 @@ func TestShouldIgnoreGroup(t *testing.T) {
 		t.Errorf("Expected group version to be ignored, got not ignored")
 	}
 }
+
+func TestFailedGroups(t *testing.T) {
+	metricsGroup := schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}
+	groupErr := &discovery.ErrGroupDiscoveryFailed{Groups: map[schema.GroupVersion]error{metricsGroup: fmt.Errorf("the server is currently unable to handle the request")}}
+
+	failed := k8s.FailedGroups(groupErr)
+	if _, ok := failed[metricsGroup]; !ok || len(failed) != 1 {
+		t.Errorf("Expected only %s to have failed discovery, got %v", metricsGroup, failed)
+	}
+	if failed := k8s.FailedGroups(fmt.Errorf("connection refused")); failed != nil {
+		t.Errorf("Expected no failed groups for a non-discovery error, got %v", failed)
+	}
+	if failed := k8s.FailedGroups(nil); failed != nil {
+		t.Errorf("Expected no failed groups without an error, got %v", failed)
+	}
+}
+
+func TestRemoveFinalizers(t *testing.T) {
+	resourceClient := newWidgetClient(newWidget())
+
+	result, err := k8s.RemoveFinalizersWithClient(resourceClient, "widget", func(finalizer string) bool {
+		return finalizer == "example.com/cleanup" || finalizer == "example.com/backup"
+	}, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(result.RemovedFinalizers) != 2 || len(result.RemainingFinalizers) != 1 || result.Attempts != 1 {
+		t.Errorf("Expected 2 removed and 1 remaining finalizer in 1 attempt, got %+v", result)
+	}
+
+	updated, err := resourceClient.Get(context.Background(), "widget", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if finalizers := updated.GetFinalizers(); len(finalizers) != 1 || finalizers[0] != "kubernetes.io/pvc-protection" {
+		t.Errorf("Expected only kubernetes.io/pvc-protection to remain, got %v", finalizers)
+	}
+}
+
+func TestRemoveFinalizersRetriesOnConflict(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newWidget())
+	conflicts := 0
+	client.PrependReactor("patch", "widgets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if patch := string(action.(k8stesting.PatchAction).GetPatch()); !strings.Contains(patch, `{"op":"test","path":"/metadata/resourceVersion","value":"42"}`) {
+			t.Errorf("Expected the patch to test the resourceVersion that was read, got %s", patch)
+		}
+		if conflicts > 0 {
+			return false, nil, nil
+		}
+		conflicts++
+		return true, nil, errors.NewConflict(schema.GroupResource{Group: "example.com", Resource: "widgets"}, "widget", nil)
+	})
+	resourceClient := client.Resource(widgetsResource).Namespace("default")
+
+	result, err := k8s.RemoveFinalizersWithClient(resourceClient, "widget", func(string) bool { return true }, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Attempts != 2 || len(result.RemovedFinalizers) != 3 {
+		t.Errorf("Expected 3 finalizers removed in 2 attempts, got %+v", result)
+	}
+}
+
+func TestRemoveFinalizersRetriesOnFailedTest(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newWidget())
+	failures := 0
+	client.PrependReactor("patch", "widgets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failures > 0 {
+			return false, nil, nil
+		}
+		failures++
+		// Another writer changed the object, so the test operation on its resourceVersion fails.
+		changed := newWidget()
+		changed.SetResourceVersion("43")
+		if err := client.Tracker().Update(widgetsResource, changed, "default"); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return true, nil, unprocessableEntity()
+	})
+	resourceClient := client.Resource(widgetsResource).Namespace("default")
+
+	result, err := k8s.RemoveFinalizersWithClient(resourceClient, "widget", func(finalizer string) bool {
+		return finalizer == "example.com/cleanup"
+	}, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Attempts != 2 || len(result.RemovedFinalizers) != 1 {
+		t.Errorf("Expected 1 finalizer removed in 2 attempts, got %+v", result)
+	}
+}
+
+func TestRemoveFinalizersDoesNotRetryInvalidPatch(t *testing.T) {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), newWidget())
+	client.PrependReactor("patch", "widgets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		// e.g. a validating webhook rejecting the change while the object stays as it was read.
+		return true, nil, unprocessableEntity()
+	})
+	resourceClient := client.Resource(widgetsResource).Namespace("default")
+
+	result, err := k8s.RemoveFinalizersWithClient(resourceClient, "widget", func(string) bool { return true }, false)
+	if err == nil {
+		t.Error("Expected the invalid patch to fail")
+	}
+	if result.Attempts != 1 {
+		t.Errorf("Expected an invalid patch not to be retried, got %d attempts", result.Attempts)
+	}
+}
+
+func TestForceDeleteAlreadyGone(t *testing.T) {
+	resourceClient := newWidgetClient()
+
+	result, err := k8s.ForceDeleteWithClient(resourceClient, "widget", func(string) bool { return true }, false)
+	if err != nil {
+		t.Fatalf("Expected an object that is already gone to succeed, got %v", err)
+	}
+	if !result.AlreadyGone || result.Deleted {
+		t.Errorf("Expected the object to be reported as already gone, got %+v", result)
+	}
+}
+
+func TestFinalizeNamespace(t *testing.T) {
+	now := metav1.Now()
+	clientset := kubernetesfake.NewSimpleClientset(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a", DeletionTimestamp: &now},
+		Spec:       v1.NamespaceSpec{Finalizers: []v1.FinalizerName{v1.FinalizerKubernetes, "example.com/audit"}},
+		Status:     v1.NamespaceStatus{Phase: v1.NamespaceTerminating},
+	})
+
+	result, err := k8s.FinalizeNamespace(clientset, "team-a", func(finalizer string) bool { return finalizer == "kubernetes" }, false)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(result.RemovedFinalizers) != 1 || len(result.RemainingFinalizers) != 1 {
+		t.Errorf("Expected the kubernetes finalizer to be removed and one to remain, got %+v", result)
+	}
+
+	namespace, err := clientset.CoreV1().Namespaces().Get(context.Background(), "team-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if finalizers := namespace.Spec.Finalizers; len(finalizers) != 1 || finalizers[0] != "example.com/audit" {
+		t.Errorf("Expected only example.com/audit to remain, got %v", finalizers)
+	}
+
+	result, err = k8s.FinalizeNamespace(clientset, "gone", func(string) bool { return true }, false)
+	if err != nil || !result.AlreadyGone {
+		t.Errorf("Expected a namespace that is already gone to succeed, got %+v, %v", result, err)
+	}
+}
+
+var widgetsResource = schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+
+// newWidget returns a custom resource carrying three finalizers.
+func newWidget() *unstructured.Unstructured {
+	widget := &unstructured.Unstructured{}
+	widget.SetAPIVersion("example.com/v1")
+	widget.SetKind("Widget")
+	widget.SetNamespace("default")
+	widget.SetName("widget")
+	widget.SetResourceVersion("42")
+	widget.SetFinalizers([]string{"example.com/cleanup", "kubernetes.io/pvc-protection", "example.com/backup"})
+	return widget
+}
+
+// unprocessableEntity returns the bare 422 a real apiserver answers both a failed test operation and an
+// invalid patch with.
+func unprocessableEntity() error {
+	return &errors.StatusError{ErrStatus: metav1.Status{
+		Status: metav1.StatusFailure,
+		Code:   http.StatusUnprocessableEntity,
+		Reason: metav1.StatusReasonInvalid,
+	}}
+}
+
+// newWidgetClient returns a fake dynamic client for widgets in the default namespace.
+func newWidgetClient(objects ...runtime.Object) dynamic.ResourceInterface {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{widgetsResource: "WidgetList"}, objects...)
+	return client.Resource(widgetsResource).Namespace("default")
+}
+
+func newPodMetadata(ns, name string) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+	}
+}
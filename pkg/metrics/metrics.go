@@ -3,7 +3,9 @@ package metrics
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,17 +14,36 @@ import (
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 var logger = logging.SetupLogging()
 
+// maxResolvedObjects bounds the number of resolved objects kept in memory.
+const maxResolvedObjects = 1000
+
+var (
+	discoveryFailures   = make([]DiscoveryFailure, 0)
+	discoveryFailuresMu sync.Mutex
+)
+
 var (
-	stuckObjects      []StuckObject
+	stuckObjects      = make(map[string]*StuckObject)
+	resolvedObjects   []ResolvedObject
+	seenThisScan      map[string]bool
 	stuckObjectsMutex sync.Mutex
 )
 
-var mu sync.Mutex
+// extraHandlers are the additional endpoints registered by other packages.
+var extraHandlers []registeredHandler
+
+// registeredHandler is an endpoint served by the metrics server in addition to the built-in ones.
+type registeredHandler struct {
+	pattern string
+	handler http.HandlerFunc
+}
 
 // Prometheus metrics
 var (
@@ -46,30 +67,152 @@ var (
 		Name: "k8s_deletion_inspector_stuck_resources_total",
 		Help: "Number of stuck objects",
 	})
+
+	stuckObjectsByScope = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_stuck_resources",
+		Help: "Number of stuck objects by scope (Namespaced or Cluster)",
+	}, []string{"scope"})
+
+	terminatingNamespaces = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_terminating_namespaces",
+		Help: "Number of namespaces stuck in the Terminating phase",
+	})
+
+	resolvedStuckObjects = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "k8s_deletion_inspector_resolved_resources_total",
+		Help: "Total number of stuck objects that have since disappeared from the cluster",
+	})
+
+	resolvedStuckDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "k8s_deletion_inspector_resolved_stuck_duration_seconds",
+		Help:    "How long resolved objects were stuck deleting, in seconds",
+		Buckets: prometheus.ExponentialBuckets(60, 4, 8),
+	})
+
+	remediationPlanned = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_remediation_planned",
+		Help: "Number of remediation actions in the latest plan by action and dry-run",
+	}, []string{"action", "dry_run"})
+
+	remediationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_deletion_inspector_remediations_total",
+		Help: "Total number of remediation actions executed by action, dry-run and result",
+	}, []string{"action", "dry_run", "result"})
+
+	remediationCircuitOpen = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_remediation_circuit_open",
+		Help: "Whether the circuit breaker has halted remediation (1) or not (0)",
+	})
+
+	stuckObjectsByController = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_stuck_resources_by_controller",
+		Help: "Number of stuck objects waiting on a finalizer by the controller owning it and the controller's status",
+	}, []string{"controller", "status"})
+
+	clusterBlockers = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_cluster_blockers",
+		Help: "Number of unavailable APIServices and webhooks that can block deletion, by kind",
+	}, []string{"kind"})
+
+	discoveryFailedGroups = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_discovery_failed_groups",
+		Help: "API group versions that failed discovery during the latest scan (1 per failed group version)",
+	}, []string{"group_version"})
+
+	watchedResources = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8s_deletion_inspector_watched_resources",
+		Help: "Number of resources watched by informers in watch mode",
+	})
+)
+
+// Scopes of a stuck object, matching the scope names used by CustomResourceDefinitions.
+const (
+	ScopeNamespaced = "Namespaced"
+	ScopeCluster    = "Cluster"
 )
 
 // StuckObject represents a stuck object in the Kubernetes cluster
 type StuckObject struct {
-	Namespace            string                      `json:"namespace"`
-	Resource             string                      `json:"resource"`
-	Name                 string                      `json:"name"`
-	DeleteTimestamp      time.Time                   `json:"deleteTimestamp"`
-	GroupVersionResource schema.GroupVersionResource `json:"groupVersionResource"`
+	Scope                      string                      `json:"scope"`
+	Namespace                  string                      `json:"namespace,omitempty"`
+	Resource                   string                      `json:"resource"`
+	Name                       string                      `json:"name"`
+	UID                        types.UID                   `json:"uid"`
+	ResourceVersion            string                      `json:"resourceVersion"`
+	Generation                 int64                       `json:"generation,omitempty"`
+	DeleteTimestamp            time.Time                   `json:"deleteTimestamp"`
+	DeletionGracePeriodSeconds *int64                      `json:"deletionGracePeriodSeconds,omitempty"`
+	Finalizers                 []string                    `json:"finalizers,omitempty"`
+	OwnerReferences            []metav1.OwnerReference     `json:"ownerReferences,omitempty"`
+	Labels                     map[string]string           `json:"labels,omitempty"`
+	Annotations                map[string]string           `json:"annotations,omitempty"`
+	GroupVersionResource       schema.GroupVersionResource `json:"groupVersionResource"`
+	FirstSeen                  time.Time                   `json:"firstSeen"`
+	LastSeen                   time.Time                   `json:"lastSeen"`
+	ConsecutiveScans           int                         `json:"consecutiveScans"`
+	// Controllers reports the health of the controllers registered as owning the object's finalizers.
+	Controllers []ControllerStatus `json:"controllers,omitempty"`
+}
+
+// Statuses of the controller owning a finalizer.
+const (
+	ControllerReady        = "Ready"
+	ControllerNotReady     = "NotReady"
+	ControllerScaledToZero = "ScaledToZero"
+	ControllerMissing      = "Missing"
+	ControllerUnknown      = "Unknown"
+)
+
+// ControllerStatus is the health of the controller responsible for removing a finalizer.
+type ControllerStatus struct {
+	Finalizer       string   `json:"finalizer"`
+	Controller      string   `json:"controller"`
+	Status          string   `json:"status"`
+	Workloads       []string `json:"workloads,omitempty"`
+	DesiredReplicas int32    `json:"desiredReplicas"`
+	ReadyReplicas   int32    `json:"readyReplicas"`
+	Message         string   `json:"message,omitempty"`
+}
+
+// DiscoveryFailure is an API group version that could not be discovered. Its resources are not scanned,
+// and the namespace controller cannot delete them either, which often leaves namespaces stuck.
+type DiscoveryFailure struct {
+	GroupVersion string    `json:"groupVersion"`
+	Error        string    `json:"error"`
+	FirstSeen    time.Time `json:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// ResolvedObject is a stuck object that has disappeared from the cluster.
+type ResolvedObject struct {
+	StuckObject
+	ResolvedAt    time.Time `json:"resolvedAt"`
+	StuckDuration string    `json:"stuckDuration"`
 }
 
 // Set up Prometheus metrics
 func init() {
 	logger.Debug("Initializing Prometheus metrics")
-	prometheus.MustRegister(namespaceCount, scanDuration, totalObjectsScanned, numberStuckObjects)
+	prometheus.MustRegister(namespaceCount, scanDuration, totalObjectsScanned, numberStuckObjects, stuckObjectsByScope, resolvedStuckObjects, resolvedStuckDuration, terminatingNamespaces, remediationPlanned, remediationsTotal, remediationCircuitOpen, stuckObjectsByController, clusterBlockers, discoveryFailedGroups, watchedResources)
 }
 
-// GetStuckObjectsHandler handles requests for stuck objects in the cluster
+// GetStuckObjectsHandler handles requests for stuck objects in the cluster.
+// The optional scope query parameter limits the response to Namespaced or Cluster objects.
 func GetStuckObjectsHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debug("Handling request for stuck objects")
-	mu.Lock()
-	defer mu.Unlock()
+	response := GetStuckObjects()
+	if scope := r.URL.Query().Get("scope"); scope != "" {
+		filtered := make([]StuckObject, 0)
+		for _, stuckObject := range response {
+			if strings.EqualFold(stuckObject.Scope, scope) {
+				filtered = append(filtered, stuckObject)
+			}
+		}
+		response = filtered
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(stuckObjects); err != nil {
+	if err := json.NewEncoder(w).Encode(response); err != nil {
 		logger.Errorf("Failed to encode stuck objects: %v", err)
 		http.Error(w, "Failed to encode stuck objects", http.StatusInternalServerError)
 		return
@@ -77,33 +220,221 @@ func GetStuckObjectsHandler(w http.ResponseWriter, r *http.Request) {
 	logger.Debug("Successfully encoded stuck objects")
 }
 
-// AddStuckObject adds a stuck object to the list of stuck objects in memory and Prometheus metrics
-func AddStuckObject(namespace string, gvr schema.GroupVersionResource, object string, deletionTimestamp time.Time) {
-	logger.Debugf("Adding stuck object: namespace=%s, resource=%s, object=%s, deletionTimestamp=%s", namespace, gvr.Resource, object, deletionTimestamp)
+// GetResolvedObjectsHandler handles requests for stuck objects that have since disappeared from the cluster
+func GetResolvedObjectsHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for resolved objects")
+	stuckObjectsMutex.Lock()
+	response := make([]ResolvedObject, len(resolvedObjects))
+	copy(response, resolvedObjects)
+	stuckObjectsMutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		logger.Errorf("Failed to encode resolved objects: %v", err)
+		http.Error(w, "Failed to encode resolved objects", http.StatusInternalServerError)
+	}
+}
+
+// BeginScan starts a scan cycle. Objects not added again before EndScan are recorded as resolved.
+func BeginScan() {
+	logger.Debug("Beginning stuck object scan cycle")
+	stuckObjectsMutex.Lock()
+	defer stuckObjectsMutex.Unlock()
+	seenThisScan = make(map[string]bool)
+}
+
+// EndScan completes a scan cycle, resolving every stuck object that was not seen during it.
+// Objects of resources that could not be listed are kept, since their absence proves nothing.
+func EndScan(failedResources map[schema.GroupVersionResource]bool) {
+	logger.Debug("Ending stuck object scan cycle")
+	stuckObjectsMutex.Lock()
+	defer stuckObjectsMutex.Unlock()
+
+	now := time.Now()
+	for key, stuckObject := range stuckObjects {
+		if seenThisScan[key] || failedResources[stuckObject.GroupVersionResource] {
+			continue
+		}
+		resolve(key, now)
+	}
+	seenThisScan = nil
+	updateStuckObjectGauges()
+}
+
+// AddStuckObject adds or refreshes a stuck object, keyed by UID, in memory and Prometheus metrics
+func AddStuckObject(scope string, gvr schema.GroupVersionResource, object metav1.Object) {
+	logger.Debugf("Adding stuck object: scope=%s, namespace=%s, resource=%s, object=%s, finalizers=%v", scope, object.GetNamespace(), gvr.Resource, object.GetName(), object.GetFinalizers())
+	stuckObject := NewStuckObject(scope, gvr, object)
+	key := stuckObjectKey(&stuckObject)
+	now := time.Now()
+
 	stuckObjectsMutex.Lock()
 	defer stuckObjectsMutex.Unlock()
 
+	stuckObject.FirstSeen = now
+	stuckObject.LastSeen = now
+	stuckObject.ConsecutiveScans = 1
+	if existing, ok := stuckObjects[key]; ok {
+		stuckObject.FirstSeen = existing.FirstSeen
+		stuckObject.ConsecutiveScans = existing.ConsecutiveScans
+		stuckObject.Controllers = existing.Controllers
+		if seenThisScan != nil && !seenThisScan[key] {
+			stuckObject.ConsecutiveScans++
+		}
+	}
+	if seenThisScan != nil {
+		seenThisScan[key] = true
+	}
+
+	stuckObjects[key] = &stuckObject
+	updateStuckObjectGauges()
+	logger.Debugf("Stuck object added: %+v", stuckObject)
+}
+
+// NewStuckObject builds a stuck object from an object's metadata, keeping only the labels
+// and annotations configured as being of interest.
+func NewStuckObject(scope string, gvr schema.GroupVersionResource, object metav1.Object) StuckObject {
 	stuckObject := StuckObject{
-		Namespace:            namespace,
-		Resource:             gvr.Resource,
-		Name:                 object,
-		DeleteTimestamp:      deletionTimestamp,
-		GroupVersionResource: gvr,
+		Scope:                      scope,
+		Namespace:                  object.GetNamespace(),
+		Resource:                   gvr.Resource,
+		Name:                       object.GetName(),
+		UID:                        object.GetUID(),
+		ResourceVersion:            object.GetResourceVersion(),
+		Generation:                 object.GetGeneration(),
+		DeletionGracePeriodSeconds: object.GetDeletionGracePeriodSeconds(),
+		Finalizers:                 object.GetFinalizers(),
+		OwnerReferences:            object.GetOwnerReferences(),
+		Labels:                     filterKeys(object.GetLabels(), config.CFG.LabelsOfInterest),
+		Annotations:                filterKeys(object.GetAnnotations(), config.CFG.AnnotationsOfInterest),
+		GroupVersionResource:       gvr,
+	}
+	if deletionTimestamp := object.GetDeletionTimestamp(); deletionTimestamp != nil {
+		stuckObject.DeleteTimestamp = deletionTimestamp.Time
+	}
+	return stuckObject
+}
+
+// filterKeys returns the entries of values whose key matches one of keys. A key ending in "/" matches every key with that prefix.
+func filterKeys(values map[string]string, keys []string) map[string]string {
+	var filtered map[string]string
+	for key, value := range values {
+		for _, wanted := range keys {
+			if key == wanted || (strings.HasSuffix(wanted, "/") && strings.HasPrefix(key, wanted)) {
+				if filtered == nil {
+					filtered = make(map[string]string)
+				}
+				filtered[key] = value
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// RemoveStuckObject records a stuck object as resolved once it has disappeared from the cluster
+func RemoveStuckObject(uid types.UID) {
+	logger.Debugf("Removing stuck object: uid=%s", uid)
+	stuckObjectsMutex.Lock()
+	defer stuckObjectsMutex.Unlock()
+
+	if _, ok := stuckObjects[string(uid)]; ok {
+		resolve(string(uid), time.Now())
+		updateStuckObjectGauges()
+	}
+}
+
+// SkipStuckObject stops reporting a stuck object that has been annotated to be skipped. It is still stuck, so it
+// is not recorded as resolved.
+func SkipStuckObject(uid types.UID) {
+	stuckObjectsMutex.Lock()
+	defer stuckObjectsMutex.Unlock()
+
+	if stuckObject, ok := stuckObjects[string(uid)]; ok {
+		logger.Infof("No longer reporting stuck object %s of resource %s in namespace %s, which is skipped", stuckObject.Name, stuckObject.Resource, stuckObject.Namespace)
+		delete(stuckObjects, string(uid))
+		updateStuckObjectGauges()
+	}
+}
+
+// resolve moves a stuck object to the resolved list. The caller must hold stuckObjectsMutex.
+func resolve(key string, now time.Time) {
+	stuckObject := stuckObjects[key]
+	delete(stuckObjects, key)
+
+	stuckDuration := now.Sub(stuckObject.DeleteTimestamp)
+	logger.Infof("Stuck object %s of resource %s in namespace %s resolved after %s", stuckObject.Name, stuckObject.Resource, stuckObject.Namespace, stuckDuration.Round(time.Second))
+	resolvedStuckObjects.Inc()
+	resolvedStuckDuration.Observe(stuckDuration.Seconds())
+
+	resolvedObjects = append(resolvedObjects, ResolvedObject{
+		StuckObject:   *stuckObject,
+		ResolvedAt:    now,
+		StuckDuration: stuckDuration.Round(time.Second).String(),
+	})
+	if len(resolvedObjects) > maxResolvedObjects {
+		resolvedObjects = resolvedObjects[len(resolvedObjects)-maxResolvedObjects:]
+	}
+}
+
+// stuckObjectKey returns the key of a stuck object, which is its UID when known.
+func stuckObjectKey(stuckObject *StuckObject) string {
+	if stuckObject.UID != "" {
+		return string(stuckObject.UID)
 	}
+	return stuckObject.GroupVersionResource.String() + "/" + stuckObject.Namespace + "/" + stuckObject.Name
+}
 
-	stuckObjects = append(stuckObjects, stuckObject)
+// updateStuckObjectGauges refreshes the stuck object gauges. The caller must hold stuckObjectsMutex.
+func updateStuckObjectGauges() {
+	counts := map[string]int{ScopeNamespaced: 0, ScopeCluster: 0}
+	for _, stuckObject := range stuckObjects {
+		counts[stuckObject.Scope]++
+	}
+	for scope, count := range counts {
+		stuckObjectsByScope.WithLabelValues(scope).Set(float64(count))
+	}
 	numberStuckObjects.Set(float64(len(stuckObjects)))
-	logger.Debugf("Stuck object added: %+v", stuckObject)
 }
 
-// GetStuckObjects returns the list of stuck objects in the cluster
+// SetStuckObjectControllers records the health of the controllers owning a stuck object's finalizers.
+func SetStuckObjectControllers(obj StuckObject, controllers []ControllerStatus) {
+	stuckObjectsMutex.Lock()
+	defer stuckObjectsMutex.Unlock()
+	if stuckObject, ok := stuckObjects[stuckObjectKey(&obj)]; ok {
+		stuckObject.Controllers = controllers
+	}
+}
+
+// WriteStuckObjectsByController sets the number of stuck objects waiting on each controller, by the controller's status.
+func WriteStuckObjectsByController(counts map[string]map[string]int) {
+	stuckObjectsByController.Reset()
+	for controller, statuses := range counts {
+		for status, count := range statuses {
+			stuckObjectsByController.WithLabelValues(controller, status).Set(float64(count))
+		}
+	}
+}
+
+// GetStuckObjects returns a copy of the stuck objects in the cluster, oldest first
 func GetStuckObjects() []StuckObject {
 	logger.Debug("Fetching stuck objects")
 	stuckObjectsMutex.Lock()
 	defer stuckObjectsMutex.Unlock()
 
-	logger.Debugf("Returning %d stuck objects", len(stuckObjects))
-	return stuckObjects
+	result := make([]StuckObject, 0, len(stuckObjects))
+	for _, stuckObject := range stuckObjects {
+		result = append(result, *stuckObject)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].FirstSeen.Equal(result[j].FirstSeen) {
+			return result[i].FirstSeen.Before(result[j].FirstSeen)
+		}
+		return stuckObjectKey(&result[i]) < stuckObjectKey(&result[j])
+	})
+
+	logger.Debugf("Returning %d stuck objects", len(result))
+	return result
 }
 
 // WriteNamespaceCount sets namespace count for Prometheus metrics
@@ -112,6 +443,92 @@ func WriteNamespaceCount(count int) {
 	namespaceCount.Set(float64(count))
 }
 
+// WriteTerminatingNamespaceCount sets the number of Terminating namespaces for Prometheus metrics
+func WriteTerminatingNamespaceCount(count int) {
+	logger.Debugf("Setting terminating namespace count to %d", count)
+	terminatingNamespaces.Set(float64(count))
+}
+
+// WriteDiscoveryFailures replaces the API group versions that failed discovery, keeping when each was first seen.
+func WriteDiscoveryFailures(failed map[schema.GroupVersion]error) {
+	discoveryFailuresMu.Lock()
+	defer discoveryFailuresMu.Unlock()
+
+	firstSeen := make(map[string]time.Time, len(discoveryFailures))
+	for _, failure := range discoveryFailures {
+		firstSeen[failure.GroupVersion] = failure.FirstSeen
+	}
+
+	now := time.Now()
+	failures := make([]DiscoveryFailure, 0, len(failed))
+	discoveryFailedGroups.Reset()
+	for groupVersion, err := range failed {
+		failure := DiscoveryFailure{GroupVersion: groupVersion.String(), Error: err.Error(), FirstSeen: now, LastSeen: now}
+		if seen, ok := firstSeen[failure.GroupVersion]; ok {
+			failure.FirstSeen = seen
+		}
+		failures = append(failures, failure)
+		discoveryFailedGroups.WithLabelValues(failure.GroupVersion).Set(1)
+	}
+	sort.Slice(failures, func(i, j int) bool { return failures[i].GroupVersion < failures[j].GroupVersion })
+	discoveryFailures = failures
+}
+
+// GetDiscoveryFailures returns a copy of the API group versions that failed discovery during the latest scan.
+func GetDiscoveryFailures() []DiscoveryFailure {
+	discoveryFailuresMu.Lock()
+	defer discoveryFailuresMu.Unlock()
+	failures := make([]DiscoveryFailure, len(discoveryFailures))
+	copy(failures, discoveryFailures)
+	return failures
+}
+
+// GetDiscoveryFailuresHandler returns the API group versions that failed discovery as JSON.
+func GetDiscoveryFailuresHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for discovery failures")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GetDiscoveryFailures()); err != nil {
+		logger.Errorf("Failed to encode discovery failures: %v", err)
+		http.Error(w, "Failed to encode discovery failures", http.StatusInternalServerError)
+	}
+}
+
+// WriteClusterBlockers sets the number of cluster-level deletion blockers by kind.
+func WriteClusterBlockers(counts map[string]int) {
+	for kind, count := range counts {
+		clusterBlockers.WithLabelValues(kind).Set(float64(count))
+	}
+}
+
+// WriteWatchedResourceCount sets the number of resources watched by informers for Prometheus metrics
+func WriteWatchedResourceCount(count int) {
+	logger.Debugf("Setting watched resource count to %d", count)
+	watchedResources.Set(float64(count))
+}
+
+// WriteRemediationPlan sets the number of planned remediation actions for Prometheus metrics
+func WriteRemediationPlan(counts map[string]int, dryRun bool) {
+	logger.Debugf("Setting remediation plan counts to %v (dry-run: %t)", counts, dryRun)
+	remediationPlanned.Reset()
+	for action, count := range counts {
+		remediationPlanned.WithLabelValues(action, strconv.FormatBool(dryRun)).Set(float64(count))
+	}
+}
+
+// RecordRemediation records the result of an executed remediation action for Prometheus metrics
+func RecordRemediation(action string, dryRun bool, result string) {
+	remediationsTotal.WithLabelValues(action, strconv.FormatBool(dryRun), result).Inc()
+}
+
+// WriteRemediationCircuitOpen sets whether the circuit breaker has halted remediation for Prometheus metrics
+func WriteRemediationCircuitOpen(open bool) {
+	if open {
+		remediationCircuitOpen.Set(1)
+		return
+	}
+	remediationCircuitOpen.Set(0)
+}
+
 // RecordScanMetrics records scan metrics for Prometheus metrics
 func RecordScanMetrics(start time.Time, namespaces, objects int) {
 	duration := time.Since(start).Seconds()
@@ -120,6 +537,12 @@ func RecordScanMetrics(start time.Time, namespaces, objects int) {
 	totalObjectsScanned.Add(float64(objects))
 }
 
+// RegisterHandler registers an additional endpoint on the metrics server. It must be called before StartMetricsServer.
+func RegisterHandler(pattern string, handler http.HandlerFunc) {
+	logger.Debugf("Registering handler for %s", pattern)
+	extraHandlers = append(extraHandlers, registeredHandler{pattern: pattern, handler: handler})
+}
+
 // StartMetricsServer starts the metrics server
 func StartMetricsServer() {
 	logger.Debug("Starting metrics server setup")
@@ -129,6 +552,11 @@ func StartMetricsServer() {
 	mux.HandleFunc("/readyz", health.ReadyzHandler())
 	mux.HandleFunc("/version", health.VersionHandler())
 	mux.HandleFunc("/stuck-objects", GetStuckObjectsHandler)
+	mux.HandleFunc("/resolved-objects", GetResolvedObjectsHandler)
+	mux.HandleFunc("/discovery-failures", GetDiscoveryFailuresHandler)
+	for _, extra := range extraHandlers {
+		mux.HandleFunc(extra.pattern, extra.handler)
+	}
 
 	serverPortStr := strconv.Itoa(config.CFG.MetricsPort)
 	logger.Printf("Metrics server starting on port %s\n", serverPortStr)
@@ -1,11 +1,15 @@
 package metrics
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func TestMetricsEndpoint(t *testing.T) {
@@ -26,3 +30,120 @@ func TestMetricsEndpoint(t *testing.T) {
 		t.Errorf("Handler returned empty body")
 	}
 }
+
+func TestGetStuckObjectsHandlerScope(t *testing.T) {
+	pvs := schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumes"}
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	AddStuckObject(ScopeCluster, pvs, &metav1.ObjectMeta{Name: "test-pv", UID: "pv-uid"})
+	AddStuckObject(ScopeNamespaced, pods, &metav1.ObjectMeta{Namespace: "default", Name: "test-pod", UID: "pod-uid"})
+	defer RemoveStuckObject("pv-uid")
+	defer RemoveStuckObject("pod-uid")
+
+	req, err := http.NewRequest("GET", "/stuck-objects?scope=Cluster", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	GetStuckObjectsHandler(rr, req)
+
+	var response []StuckObject
+	if err := json.NewDecoder(rr.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response) != 1 {
+		t.Fatalf("Expected 1 stuck object, got %d", len(response))
+	}
+	if response[0].Scope != ScopeCluster || response[0].Name != "test-pv" {
+		t.Errorf("Expected cluster-scoped object test-pv, got %+v", response[0])
+	}
+}
+
+func TestFilterKeys(t *testing.T) {
+	values := map[string]string{
+		"app":                          "web",
+		"app.kubernetes.io/managed-by": "Helm",
+		"team":                         "payments",
+	}
+
+	filtered := filterKeys(values, []string{"app", "app.kubernetes.io/"})
+	if len(filtered) != 2 {
+		t.Errorf("Expected 2 entries, got %v", filtered)
+	}
+	if _, ok := filtered["team"]; ok {
+		t.Errorf("Expected 'team' to be filtered out, got %v", filtered)
+	}
+}
+
+func TestStuckObjectLifecycle(t *testing.T) {
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	stuck := &metav1.ObjectMeta{Namespace: "default", Name: "stuck-pod", UID: "stuck-uid"}
+	gone := &metav1.ObjectMeta{Namespace: "default", Name: "gone-pod", UID: "gone-uid"}
+	defer RemoveStuckObject("stuck-uid")
+
+	BeginScan()
+	AddStuckObject(ScopeNamespaced, pods, stuck)
+	AddStuckObject(ScopeNamespaced, pods, gone)
+	EndScan(nil)
+
+	BeginScan()
+	AddStuckObject(ScopeNamespaced, pods, stuck)
+	AddStuckObject(ScopeNamespaced, pods, stuck)
+	EndScan(nil)
+
+	var found *StuckObject
+	for _, stuckObject := range GetStuckObjects() {
+		if stuckObject.UID == "gone-uid" {
+			t.Errorf("Expected gone-pod to be resolved, still stuck")
+		}
+		if stuckObject.UID == "stuck-uid" {
+			found = &stuckObject
+		}
+	}
+	if found == nil {
+		t.Fatalf("Expected stuck-pod to still be stuck")
+	}
+	if found.ConsecutiveScans != 2 {
+		t.Errorf("Expected stuck-pod to be seen in 2 consecutive scans, got %d", found.ConsecutiveScans)
+	}
+
+	resolved := false
+	for _, resolvedObject := range resolvedObjects {
+		if resolvedObject.UID == "gone-uid" {
+			resolved = true
+		}
+	}
+	if !resolved {
+		t.Errorf("Expected gone-pod to be recorded as resolved")
+	}
+}
+
+func TestWriteDiscoveryFailures(t *testing.T) {
+	metricsGroup := schema.GroupVersion{Group: "metrics.k8s.io", Version: "v1beta1"}
+	customGroup := schema.GroupVersion{Group: "custom.metrics.k8s.io", Version: "v1beta2"}
+	defer WriteDiscoveryFailures(nil)
+
+	WriteDiscoveryFailures(map[schema.GroupVersion]error{metricsGroup: fmt.Errorf("service unavailable")})
+	first := GetDiscoveryFailures()
+	if len(first) != 1 {
+		t.Fatalf("Expected 1 discovery failure, got %+v", first)
+	}
+
+	WriteDiscoveryFailures(map[schema.GroupVersion]error{
+		metricsGroup: fmt.Errorf("service unavailable"),
+		customGroup:  fmt.Errorf("service unavailable"),
+	})
+	failures := GetDiscoveryFailures()
+	if len(failures) != 2 || failures[0].GroupVersion != customGroup.String() || failures[1].GroupVersion != metricsGroup.String() {
+		t.Fatalf("Expected failures for %s and %s, got %+v", customGroup, metricsGroup, failures)
+	}
+	if !failures[1].FirstSeen.Equal(first[0].FirstSeen) {
+		t.Errorf("Expected %s to keep its first seen time %v, got %v", metricsGroup, first[0].FirstSeen, failures[1].FirstSeen)
+	}
+
+	// A group that resolves again is no longer reported.
+	WriteDiscoveryFailures(map[schema.GroupVersion]error{customGroup: fmt.Errorf("service unavailable")})
+	if failures := GetDiscoveryFailures(); len(failures) != 1 || failures[0].GroupVersion != customGroup.String() {
+		t.Errorf("Expected only %s to have failed discovery, got %+v", customGroup, failures)
+	}
+}
@@ -0,0 +1,129 @@
+package owners
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// CheckOwner finds the workloads of a finalizer owner and reports whether its controller exists, has ready
+// replicas or is scaled to zero.
+func CheckOwner(clientset kubernetes.Interface, owner *Owner) metrics.ControllerStatus {
+	status := metrics.ControllerStatus{Controller: owner.Name}
+	listOptions := metav1.ListOptions{LabelSelector: owner.selector.String()}
+	ctx := context.Background()
+
+	switch owner.Kind {
+	case KindDeployment:
+		deployments, err := clientset.AppsV1().Deployments(owner.Namespace).List(ctx, listOptions)
+		if err != nil {
+			return unknownStatus(status, owner, err)
+		}
+		for _, deployment := range deployments.Items {
+			status.Workloads = append(status.Workloads, workloadName(owner.Kind, deployment.Namespace, deployment.Name))
+			status.DesiredReplicas += replicas(deployment.Spec.Replicas)
+			status.ReadyReplicas += deployment.Status.ReadyReplicas
+		}
+	case KindStatefulSet:
+		statefulSets, err := clientset.AppsV1().StatefulSets(owner.Namespace).List(ctx, listOptions)
+		if err != nil {
+			return unknownStatus(status, owner, err)
+		}
+		for _, statefulSet := range statefulSets.Items {
+			status.Workloads = append(status.Workloads, workloadName(owner.Kind, statefulSet.Namespace, statefulSet.Name))
+			status.DesiredReplicas += replicas(statefulSet.Spec.Replicas)
+			status.ReadyReplicas += statefulSet.Status.ReadyReplicas
+		}
+	case KindDaemonSet:
+		daemonSets, err := clientset.AppsV1().DaemonSets(owner.Namespace).List(ctx, listOptions)
+		if err != nil {
+			return unknownStatus(status, owner, err)
+		}
+		for _, daemonSet := range daemonSets.Items {
+			status.Workloads = append(status.Workloads, workloadName(owner.Kind, daemonSet.Namespace, daemonSet.Name))
+			status.DesiredReplicas += daemonSet.Status.DesiredNumberScheduled
+			status.ReadyReplicas += daemonSet.Status.NumberReady
+		}
+	}
+
+	switch {
+	case len(status.Workloads) == 0:
+		status.Status = metrics.ControllerMissing
+		status.Message = fmt.Sprintf("No %s matching %s found", owner.Kind, owner.selector)
+	case status.DesiredReplicas == 0:
+		status.Status = metrics.ControllerScaledToZero
+		status.Message = "The controller is scaled to zero, so nothing will remove the finalizer"
+	case status.ReadyReplicas == 0:
+		status.Status = metrics.ControllerNotReady
+		status.Message = fmt.Sprintf("None of the %d desired replicas are ready", status.DesiredReplicas)
+	default:
+		status.Status = metrics.ControllerReady
+	}
+	return status
+}
+
+// unknownStatus returns the status of an owner whose workloads could not be listed.
+func unknownStatus(status metrics.ControllerStatus, owner *Owner, err error) metrics.ControllerStatus {
+	logger.Errorf("Error listing %s workloads of controller %s: %v", owner.Kind, owner.Name, err)
+	status.Status = metrics.ControllerUnknown
+	status.Message = fmt.Sprintf("error listing %s workloads: %v", owner.Kind, err)
+	return status
+}
+
+// replicas returns the desired replicas of a workload, which default to one when unset.
+func replicas(desired *int32) int32 {
+	if desired == nil {
+		return 1
+	}
+	return *desired
+}
+
+// workloadName returns a workload as kind/namespace/name.
+func workloadName(kind, namespace, name string) string {
+	return kind + "/" + namespace + "/" + name
+}
+
+// Correlate reports, for each stuck object, the health of the controllers owning its finalizers. Each
+// controller is checked once per call however many objects wait on it. Finalizers without a registered
+// owner are not reported.
+func Correlate(clientset kubernetes.Interface, registry *Registry) {
+	logger.Debugln("Correlating stuck objects with the controllers owning their finalizers...")
+	checked := make(map[*Owner]metrics.ControllerStatus)
+	counts := make(map[string]map[string]int)
+
+	for _, obj := range metrics.GetStuckObjects() {
+		var controllers []metrics.ControllerStatus
+		seen := make(map[*Owner]bool)
+		for _, finalizer := range obj.Finalizers {
+			owner := registry.Lookup(finalizer)
+			if owner == nil {
+				continue
+			}
+			status, ok := checked[owner]
+			if !ok {
+				status = CheckOwner(clientset, owner)
+				checked[owner] = status
+				logger.Debugf("Controller %s owning finalizer %s is %s", owner.Name, finalizer, status.Status)
+			}
+			status.Finalizer = finalizer
+			controllers = append(controllers, status)
+
+			if !seen[owner] {
+				seen[owner] = true
+				if counts[owner.Name] == nil {
+					counts[owner.Name] = make(map[string]int)
+				}
+				counts[owner.Name][status.Status]++
+			}
+			if status.Status != metrics.ControllerReady {
+				logger.Warnf("Object %s of resource %s in namespace %s waits on finalizer %s, but its controller %s is %s: %s", obj.Name, obj.Resource, obj.Namespace, finalizer, owner.Name, status.Status, status.Message)
+			}
+		}
+		metrics.SetStuckObjectControllers(obj, controllers)
+	}
+
+	metrics.WriteStuckObjectsByController(counts)
+}
@@ -0,0 +1,187 @@
+package owners
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+var logger = logging.SetupLogging()
+
+// Kinds of workload that can own a finalizer.
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+)
+
+// Owner maps finalizers to the workload of the controller responsible for removing them.
+type Owner struct {
+	// Name identifies the controller, e.g. cert-manager.
+	Name string `json:"name"`
+	// Finalizers lists the finalizer names owned by the controller; globs are supported.
+	Finalizers []string `json:"finalizers"`
+	// Kind is the kind of the controller's workload: Deployment, StatefulSet or DaemonSet.
+	Kind string `json:"kind"`
+	// Namespace is the namespace of the workload. An empty namespace searches every namespace.
+	Namespace string `json:"namespace,omitempty"`
+	// Selector selects the controller's workload by its labels.
+	Selector *metav1.LabelSelector `json:"selector"`
+
+	selector   labels.Selector
+	finalizers policy.Globs
+}
+
+// Registry is an ordered list of finalizer owners. The first owner matching a finalizer is responsible for it.
+type Registry struct {
+	Owners []Owner `json:"owners"`
+}
+
+// builtinOwners are the finalizer owners of common operators, matched by the labels of their default install.
+var builtinOwners = []Owner{
+	{
+		Name:       "cert-manager",
+		Finalizers: []string{"finalizer.acme.cert-manager.io"},
+		Kind:       KindDeployment,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "cert-manager", "app.kubernetes.io/component": "controller"}},
+	},
+	{
+		Name:       "argocd-application-controller",
+		Finalizers: []string{"resources-finalizer.argocd.argoproj.io", "resources-finalizer.argocd.argoproj.io/*"},
+		Kind:       KindStatefulSet,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "argocd-application-controller"}},
+	},
+	{
+		Name:       "flux",
+		Finalizers: []string{"finalizers.fluxcd.io"},
+		Kind:       KindDeployment,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/part-of": "flux"}},
+	},
+	{
+		Name:       "longhorn-manager",
+		Finalizers: []string{"longhorn.io"},
+		Kind:       KindDaemonSet,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "longhorn-manager"}},
+	},
+	{
+		Name:       "rook-ceph-operator",
+		Finalizers: []string{"ceph.rook.io/*", "*.ceph.rook.io"},
+		Kind:       KindDeployment,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "rook-ceph-operator"}},
+	},
+	{
+		Name:       "rancher",
+		Finalizers: []string{"controller.cattle.io/*", "wrangler.cattle.io/*"},
+		Kind:       KindDeployment,
+		Namespace:  "cattle-system",
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app": "rancher"}},
+	},
+	{
+		Name:       "aws-load-balancer-controller",
+		Finalizers: []string{"elbv2.k8s.aws/resources", "service.k8s.aws/resources", "ingress.k8s.aws/resources"},
+		Kind:       KindDeployment,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "aws-load-balancer-controller"}},
+	},
+	{
+		Name:       "karpenter",
+		Finalizers: []string{"karpenter.sh/termination"},
+		Kind:       KindDeployment,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "karpenter"}},
+	},
+	{
+		Name:       "istio-operator",
+		Finalizers: []string{"istio-finalizer.install.istio.io"},
+		Kind:       KindDeployment,
+		Selector:   &metav1.LabelSelector{MatchLabels: map[string]string{"name": "istio-operator"}},
+	},
+}
+
+// DefaultRegistry returns the registry of built-in finalizer owners.
+func DefaultRegistry() *Registry {
+	registry, err := newRegistry(nil)
+	if err != nil {
+		// The built-in owners are static, so this only happens if one of them is invalid.
+		panic(fmt.Sprintf("invalid built-in finalizer owner: %v", err))
+	}
+	return registry
+}
+
+// LoadFile reads additional finalizer owners from a YAML file. They take precedence over the built-in owners.
+func LoadFile(filename string) (*Registry, error) {
+	data, err := os.ReadFile(filename) // #nosec G304 -- the registry file path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("error reading finalizer owner registry %s: %v", filename, err)
+	}
+	registry, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing finalizer owner registry %s: %v", filename, err)
+	}
+	logger.Infof("Loaded %d finalizer owners", len(registry.Owners))
+	return registry, nil
+}
+
+// Parse parses a YAML list of finalizer owners, followed by the built-in owners.
+func Parse(data []byte) (*Registry, error) {
+	var extra Registry
+	if err := yaml.UnmarshalStrict(data, &extra); err != nil {
+		return nil, err
+	}
+	return newRegistry(extra.Owners)
+}
+
+// newRegistry validates the given owners and returns a registry of them followed by the built-in owners.
+func newRegistry(extra []Owner) (*Registry, error) {
+	registry := &Registry{Owners: make([]Owner, 0, len(extra)+len(builtinOwners))}
+	registry.Owners = append(registry.Owners, extra...)
+	registry.Owners = append(registry.Owners, builtinOwners...)
+	for i := range registry.Owners {
+		owner := &registry.Owners[i]
+		if err := owner.compile(); err != nil {
+			return nil, fmt.Errorf("owner %d (%s): %v", i, owner.Name, err)
+		}
+	}
+	return registry, nil
+}
+
+// compile validates an owner and builds its label selector and finalizer globs.
+func (o *Owner) compile() error {
+	if o.Name == "" {
+		return fmt.Errorf("a name is required")
+	}
+	if len(o.Finalizers) == 0 {
+		return fmt.Errorf("at least one finalizer is required")
+	}
+	switch o.Kind {
+	case KindDeployment, KindStatefulSet, KindDaemonSet:
+	default:
+		return fmt.Errorf("unknown kind %q, expected %s, %s or %s", o.Kind, KindDeployment, KindStatefulSet, KindDaemonSet)
+	}
+	if o.Selector == nil {
+		return fmt.Errorf("a selector is required")
+	}
+	selector, err := metav1.LabelSelectorAsSelector(o.Selector)
+	if err != nil {
+		return fmt.Errorf("invalid selector: %v", err)
+	}
+	if selector.Empty() {
+		return fmt.Errorf("the selector must not be empty")
+	}
+	o.selector = selector
+	o.finalizers = policy.CompileGlobs(o.Finalizers)
+	return nil
+}
+
+// Lookup returns the owner responsible for a finalizer, or nil when no owner is registered for it.
+func (r *Registry) Lookup(finalizer string) *Owner {
+	for i := range r.Owners {
+		if r.Owners[i].finalizers.Matches(finalizer) {
+			return &r.Owners[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package owners
+
+import (
+	"testing"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+)
+
+const testRegistry = `
+owners:
+  - name: widget-operator
+    finalizers: ["widgets.example.com/*"]
+    kind: Deployment
+    namespace: widgets
+    selector:
+      matchLabels:
+        app: widget-operator
+  - name: cert-manager-override
+    finalizers: ["finalizer.acme.cert-manager.io"]
+    kind: StatefulSet
+    selector:
+      matchLabels:
+        app: cm
+`
+
+func TestParse(t *testing.T) {
+	registry, err := Parse([]byte(testRegistry))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		finalizer string
+		wantOwner string
+	}{
+		{finalizer: "widgets.example.com/cleanup", wantOwner: "widget-operator"},
+		// Owners from the file take precedence over the built-in owners.
+		{finalizer: "finalizer.acme.cert-manager.io", wantOwner: "cert-manager-override"},
+		{finalizer: "karpenter.sh/termination", wantOwner: "karpenter"},
+		{finalizer: "example.com/unknown", wantOwner: ""},
+	}
+	for _, tt := range tests {
+		owner := registry.Lookup(tt.finalizer)
+		got := ""
+		if owner != nil {
+			got = owner.Name
+		}
+		if got != tt.wantOwner {
+			t.Errorf("Expected finalizer %s to be owned by %q, got %q", tt.finalizer, tt.wantOwner, got)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	invalid := []string{
+		"owners:\n  - name: a\n    finalizers: [x]\n    kind: Pod\n    selector: {matchLabels: {app: a}}\n",
+		"owners:\n  - name: a\n    finalizers: [x]\n    kind: Deployment\n",
+		"owners:\n  - name: a\n    kind: Deployment\n    selector: {matchLabels: {app: a}}\n",
+		"owners:\n  - name: a\n    finalizers: [x]\n    kind: Deployment\n    selector: {}\n",
+		"owners:\n  - name: a\n    unknownField: true\n",
+	}
+	for _, data := range invalid {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Expected an error parsing %q", data)
+		}
+	}
+	// The built-in owners must be valid.
+	DefaultRegistry()
+}
+
+func TestCorrelate(t *testing.T) {
+	registry, err := Parse([]byte(`
+owners:
+  - name: ready
+    finalizers: ["ready.example.com/*"]
+    kind: Deployment
+    selector: {matchLabels: {app: ready}}
+  - name: scaled-down
+    finalizers: ["scaled.example.com/*"]
+    kind: Deployment
+    selector: {matchLabels: {app: scaled}}
+  - name: not-ready
+    finalizers: ["notready.example.com/*"]
+    kind: StatefulSet
+    selector: {matchLabels: {app: notready}}
+  - name: missing
+    finalizers: ["missing.example.com/*"]
+    kind: DaemonSet
+    selector: {matchLabels: {app: missing}}
+`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	zero, two := int32(0), int32(2)
+	clientset := kubernetesfake.NewSimpleClientset(
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ops", Name: "ready", Labels: map[string]string{"app": "ready"}},
+			Spec:       appsv1.DeploymentSpec{Replicas: &two},
+			Status:     appsv1.DeploymentStatus{ReadyReplicas: 2},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ops", Name: "scaled", Labels: map[string]string{"app": "scaled"}},
+			Spec:       appsv1.DeploymentSpec{Replicas: &zero},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "ops", Name: "notready", Labels: map[string]string{"app": "notready"}},
+			Spec:       appsv1.StatefulSetSpec{Replicas: &two},
+		},
+	)
+
+	now := metav1.Now()
+	widget := &metav1.ObjectMeta{
+		Namespace:         "team-a",
+		Name:              "widget",
+		UID:               "owners-test-widget",
+		DeletionTimestamp: &now,
+		Finalizers: []string{
+			"ready.example.com/cleanup",
+			"scaled.example.com/cleanup",
+			"notready.example.com/cleanup",
+			"missing.example.com/cleanup",
+			"unregistered.example.com/cleanup",
+		},
+	}
+	metrics.AddStuckObject(metrics.ScopeNamespaced, schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, widget)
+	defer metrics.RemoveStuckObject(widget.UID)
+
+	Correlate(clientset, registry)
+
+	var controllers []metrics.ControllerStatus
+	for _, obj := range metrics.GetStuckObjects() {
+		if obj.UID == widget.UID {
+			controllers = obj.Controllers
+		}
+	}
+	want := map[string]string{
+		"ready.example.com/cleanup":    metrics.ControllerReady,
+		"scaled.example.com/cleanup":   metrics.ControllerScaledToZero,
+		"notready.example.com/cleanup": metrics.ControllerNotReady,
+		"missing.example.com/cleanup":  metrics.ControllerMissing,
+	}
+	if len(controllers) != len(want) {
+		t.Fatalf("Expected %d controller statuses, got %+v", len(want), controllers)
+	}
+	for _, controller := range controllers {
+		if controller.Status != want[controller.Finalizer] {
+			t.Errorf("Expected the controller of %s to be %s, got %s (%s)", controller.Finalizer, want[controller.Finalizer], controller.Status, controller.Message)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"strconv"
+	"time"
+)
+
+// AnnotationPrefix is the prefix of the annotations the inspector honours on objects and namespaces.
+const AnnotationPrefix = "deletion-inspector/"
+
+// Annotations that override the policy for an object, or for every object in a namespace.
+const (
+	// AnnotationSkip excludes the object from stuck reporting and remediation when "true".
+	AnnotationSkip = AnnotationPrefix + "skip"
+	// AnnotationDeleteAfter sets how long the object may be deleting before it is remediated, e.g. "6h".
+	AnnotationDeleteAfter = AnnotationPrefix + "delete-after"
+	// AnnotationAllowForce allows the object to be force deleted when "true" and the policy only alerts on it or
+	// no rule matches it. It never overrides an explicit ignore rule.
+	AnnotationAllowForce = AnnotationPrefix + "allow-force"
+)
+
+// Overrides are the policy overrides set by annotations. Object annotations take precedence over namespace annotations.
+type Overrides struct {
+	Skip        bool
+	DeleteAfter *time.Duration
+	AllowForce  bool
+}
+
+// ParseOverrides reads the overrides from an object's annotations and those of its namespace.
+// Values that cannot be parsed are logged and ignored.
+func ParseOverrides(objectAnnotations, namespaceAnnotations map[string]string) Overrides {
+	var overrides Overrides
+	overrides.Skip = annotationBool(AnnotationSkip, objectAnnotations, namespaceAnnotations)
+	overrides.AllowForce = annotationBool(AnnotationAllowForce, objectAnnotations, namespaceAnnotations)
+	if value, ok := annotationValue(AnnotationDeleteAfter, objectAnnotations, namespaceAnnotations); ok {
+		deleteAfter, err := time.ParseDuration(value)
+		if err != nil {
+			logger.Warnf("Ignoring annotation %s=%q: %v", AnnotationDeleteAfter, value, err)
+		} else {
+			overrides.DeleteAfter = &deleteAfter
+		}
+	}
+	return overrides
+}
+
+// IsSkipped reports whether an object or its namespace is annotated to be skipped.
+func IsSkipped(objectAnnotations, namespaceAnnotations map[string]string) bool {
+	return annotationBool(AnnotationSkip, objectAnnotations, namespaceAnnotations)
+}
+
+// annotationBool returns the boolean value of an annotation, false when unset or invalid.
+func annotationBool(key string, objectAnnotations, namespaceAnnotations map[string]string) bool {
+	value, ok := annotationValue(key, objectAnnotations, namespaceAnnotations)
+	if !ok {
+		return false
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		logger.Warnf("Ignoring annotation %s=%q: %v", key, value, err)
+		return false
+	}
+	return boolValue
+}
+
+// annotationValue returns an annotation from the object, falling back to its namespace.
+func annotationValue(key string, objectAnnotations, namespaceAnnotations map[string]string) (string, bool) {
+	if value, ok := objectAnnotations[key]; ok {
+		return value, true
+	}
+	value, ok := namespaceAnnotations[key]
+	return value, ok
+}
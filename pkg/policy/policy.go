@@ -0,0 +1,292 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+var logger = logging.SetupLogging()
+
+// Actions a rule can take on a matching stuck object.
+const (
+	ActionIgnore           = "ignore"
+	ActionAlert            = "alert"
+	ActionRemoveFinalizers = "removeFinalizers"
+	ActionForceDelete      = "forceDelete"
+	// ActionFinalizeNamespace clears the spec finalizers of an empty Terminating namespace through its
+	// finalize subresource. It only applies to namespaces.
+	ActionFinalizeNamespace = "finalizeNamespace"
+)
+
+// Remediates reports whether an action changes the stuck object rather than only alerting on it.
+func Remediates(action string) bool {
+	return action == ActionRemoveFinalizers || action == ActionForceDelete || action == ActionFinalizeNamespace
+}
+
+// Policy is an ordered list of remediation rules. The first matching rule decides the action.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+
+	// DefaultAfter is how long an object annotated with allow-force waits before it is force deleted
+	// when its rule would not have force deleted it.
+	DefaultAfter time.Duration `json:"-"`
+
+	// unmatchedAction is taken for objects that no rule matches.
+	unmatchedAction string
+}
+
+// Rule matches stuck objects and decides what to do with them.
+type Rule struct {
+	Name  string `json:"name"`
+	Match Match  `json:"match"`
+	// Action is one of ignore, alert, removeFinalizers, forceDelete or finalizeNamespace.
+	Action string `json:"action"`
+	// After is how long the object must have been deleting before the action is taken.
+	After metav1.Duration `json:"after"`
+	// Finalizers lists the finalizers removed by the removeFinalizers action.
+	Finalizers []string `json:"finalizers,omitempty"`
+	// DryRun forces server-side dry-run for this rule's actions even when dry-run is disabled globally.
+	DryRun bool `json:"dryRun,omitempty"`
+
+	namespaceSelector labels.Selector
+	selector          labels.Selector
+	resources         Globs
+	namespaces        Globs
+	finalizers        Globs
+}
+
+// Match selects the stuck objects a rule applies to. Empty fields match everything.
+type Match struct {
+	// Resources are group/resource globs such as "pods", "deployments.apps" or "*.example.com".
+	Resources []string `json:"resources,omitempty"`
+	// Namespaces are namespace name globs.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector matches the labels of the object's namespace.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// Finalizers are finalizer globs; the object must carry at least one matching finalizer.
+	Finalizers []string `json:"finalizers,omitempty"`
+	// Selector matches the object's labels.
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// MinAge is how long the object must have been deleting for the rule to match.
+	MinAge metav1.Duration `json:"minAge,omitempty"`
+}
+
+// Decision is the outcome of evaluating a policy against a stuck object.
+type Decision struct {
+	Rule       string        `json:"rule"`
+	Action     string        `json:"action"`
+	After      time.Duration `json:"after"`
+	Finalizers []string      `json:"finalizers,omitempty"`
+	DryRun     bool          `json:"dryRun"`
+	// Due reports whether the object has been deleting for longer than After.
+	Due bool `json:"due"`
+	// Annotations lists the annotations that overrode the rule's decision.
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// DefaultPolicy force deletes every stuck object once it has been deleting for deleteAfter,
+// matching the behaviour of the inspector without a policy file.
+func DefaultPolicy(deleteAfter time.Duration) *Policy {
+	return &Policy{
+		Rules: []Rule{{
+			Name:   "default",
+			Action: ActionForceDelete,
+			After:  metav1.Duration{Duration: deleteAfter},
+		}},
+		DefaultAfter:    deleteAfter,
+		unmatchedAction: ActionAlert,
+	}
+}
+
+// LoadFile reads and validates a policy from a YAML file. Objects no rule matches are only alerted on.
+func LoadFile(filename string) (*Policy, error) {
+	logger.Debugf("Loading remediation policy from %s", filename)
+	data, err := os.ReadFile(filename) // #nosec G304 -- the policy file path is provided by the operator
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy file %s: %v", filename, err)
+	}
+	return Parse(data)
+}
+
+// Parse parses and validates a policy from YAML.
+func Parse(data []byte) (*Policy, error) {
+	policy := &Policy{unmatchedAction: ActionAlert}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, fmt.Errorf("error parsing policy: %v", err)
+	}
+
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("invalid rule %s: %v", rule.Name, err)
+		}
+	}
+
+	logger.Infof("Loaded remediation policy with %d rules", len(policy.Rules))
+	return policy, nil
+}
+
+// compile validates a rule and builds its label selectors and match globs.
+func (r *Rule) compile() error {
+	switch r.Action {
+	case ActionIgnore, ActionAlert, ActionForceDelete, ActionFinalizeNamespace:
+	case ActionRemoveFinalizers:
+		if len(r.Finalizers) == 0 {
+			return fmt.Errorf("action %s requires at least one finalizer", r.Action)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", r.Action)
+	}
+
+	r.resources = CompileGlobs(r.Match.Resources)
+	r.namespaces = CompileGlobs(r.Match.Namespaces)
+	r.finalizers = CompileGlobs(r.Match.Finalizers)
+
+	var err error
+	if r.Match.NamespaceSelector != nil {
+		if r.namespaceSelector, err = metav1.LabelSelectorAsSelector(r.Match.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespaceSelector: %v", err)
+		}
+	}
+	if r.Match.Selector != nil {
+		if r.selector, err = metav1.LabelSelectorAsSelector(r.Match.Selector); err != nil {
+			return fmt.Errorf("invalid selector: %v", err)
+		}
+	}
+	return nil
+}
+
+// LabelKeys returns the object label keys referenced by rule selectors, which must be recorded on stuck objects.
+func (p *Policy) LabelKeys() []string {
+	var keys []string
+	for _, rule := range p.Rules {
+		if rule.Match.Selector == nil {
+			continue
+		}
+		for key := range rule.Match.Selector.MatchLabels {
+			keys = append(keys, key)
+		}
+		for _, requirement := range rule.Match.Selector.MatchExpressions {
+			keys = append(keys, requirement.Key)
+		}
+	}
+	return keys
+}
+
+// Evaluate returns the decision of the first rule matching the stuck object, adjusted by the
+// deletion-inspector annotations of the object and its namespace. Annotations are set by application teams,
+// so allow-force only upgrades alerts, which unmatched objects get, and never overrides an ignore rule.
+func (p *Policy) Evaluate(obj metrics.StuckObject, namespace metav1.ObjectMeta, now time.Time) Decision {
+	age := now.Sub(obj.DeleteTimestamp)
+	overrides := ParseOverrides(obj.Annotations, namespace.Annotations)
+	if overrides.Skip {
+		return Decision{Rule: "annotation", Action: ActionIgnore, Due: true, Annotations: []string{AnnotationSkip}}
+	}
+
+	decision := p.evaluateRules(obj, namespace.Labels, age)
+	if overrides.AllowForce && decision.Action == ActionAlert {
+		decision.After = p.DefaultAfter
+		decision.Action = ActionForceDelete
+		decision.Annotations = append(decision.Annotations, AnnotationAllowForce)
+	}
+	if overrides.DeleteAfter != nil && Remediates(decision.Action) {
+		decision.After = *overrides.DeleteAfter
+		decision.Annotations = append(decision.Annotations, AnnotationDeleteAfter)
+	}
+	decision.Due = age > decision.After
+	return decision
+}
+
+// evaluateRules returns the decision of the first rule matching the stuck object.
+func (p *Policy) evaluateRules(obj metrics.StuckObject, namespaceLabels map[string]string, age time.Duration) Decision {
+	for _, rule := range p.Rules {
+		if !rule.matches(obj, namespaceLabels, age) {
+			continue
+		}
+		logger.Debugf("Object %s of resource %s in namespace %s matched rule %s", obj.Name, obj.Resource, obj.Namespace, rule.Name)
+		return Decision{
+			Rule:       rule.Name,
+			Action:     rule.Action,
+			After:      rule.After.Duration,
+			Finalizers: rule.Finalizers,
+			DryRun:     rule.DryRun,
+		}
+	}
+
+	return Decision{Rule: "unmatched", Action: p.unmatchedAction}
+}
+
+// matches reports whether a rule applies to a stuck object.
+func (r *Rule) matches(obj metrics.StuckObject, namespaceLabels map[string]string, age time.Duration) bool {
+	if age < r.Match.MinAge.Duration {
+		return false
+	}
+	if len(r.Match.Resources) > 0 && !r.resources.Matches(obj.GroupVersionResource.GroupResource().String()) {
+		return false
+	}
+	if len(r.Match.Namespaces) > 0 && !r.namespaces.Matches(obj.Namespace) {
+		return false
+	}
+	if r.namespaceSelector != nil && !r.namespaceSelector.Matches(labels.Set(namespaceLabels)) {
+		return false
+	}
+	if r.selector != nil && !r.selector.Matches(labels.Set(obj.Labels)) {
+		return false
+	}
+	if len(r.Match.Finalizers) > 0 {
+		found := false
+		for _, finalizer := range obj.Finalizers {
+			if r.finalizers.Matches(finalizer) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Globs is a list of compiled glob patterns. In a pattern, "*" matches any sequence of characters,
+// including "/", and "?" matches a single character.
+type Globs []*regexp.Regexp
+
+// CompileGlobs compiles glob patterns once so they can be matched repeatedly.
+func CompileGlobs(patterns []string) Globs {
+	globs := make(Globs, 0, len(patterns))
+	for _, pattern := range patterns {
+		globs = append(globs, globToRegexp(pattern))
+	}
+	return globs
+}
+
+// Matches reports whether value matches one of the globs.
+func (g Globs) Matches(value string) bool {
+	for _, glob := range g {
+		if glob.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// globToRegexp compiles a glob pattern into an anchored regular expression.
+func globToRegexp(pattern string) *regexp.Regexp {
+	expression := regexp.QuoteMeta(pattern)
+	expression = strings.ReplaceAll(expression, `\*`, ".*")
+	expression = strings.ReplaceAll(expression, `\?`, ".")
+	return regexp.MustCompile("^" + expression + "$")
+}
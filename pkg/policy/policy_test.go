@@ -0,0 +1,238 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const testPolicy = `
+rules:
+  - name: keep-volumes
+    match:
+      resources: ["persistentvolumeclaims"]
+    action: ignore
+  - name: empty-namespaces
+    match:
+      resources: ["namespaces"]
+    action: finalizeNamespace
+    after: 2h
+  - name: dev-widgets
+    match:
+      resources: ["*.example.com"]
+      finalizers: ["example.com/*"]
+      namespaceSelector:
+        matchLabels:
+          environment: dev
+    action: removeFinalizers
+    finalizers: ["example.com/cleanup"]
+    after: 1h
+    dryRun: true
+  - name: old-team-objects
+    match:
+      namespaces: ["team-*"]
+      selector:
+        matchLabels:
+          app: web
+      minAge: 24h
+    action: forceDelete
+`
+
+func TestEvaluate(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	now := time.Now()
+	widgets := schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	tests := []struct {
+		name       string
+		obj        metrics.StuckObject
+		namespace  metav1.ObjectMeta
+		wantRule   string
+		wantAction string
+		wantDue    bool
+	}{
+		{
+			name:       "ignored resource",
+			obj:        metrics.StuckObject{Namespace: "dev", GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, DeleteTimestamp: now.Add(-48 * time.Hour)},
+			wantRule:   "keep-volumes",
+			wantAction: ActionIgnore,
+			wantDue:    true,
+		},
+		{
+			name:       "finalizer and namespace selector match, not yet due",
+			obj:        metrics.StuckObject{Namespace: "dev", GroupVersionResource: widgets, Finalizers: []string{"example.com/cleanup"}, DeleteTimestamp: now.Add(-30 * time.Minute)},
+			namespace:  metav1.ObjectMeta{Labels: map[string]string{"environment": "dev"}},
+			wantRule:   "dev-widgets",
+			wantAction: ActionRemoveFinalizers,
+			wantDue:    false,
+		},
+		{
+			name:       "namespace selector does not match",
+			obj:        metrics.StuckObject{Namespace: "prod", GroupVersionResource: widgets, Finalizers: []string{"example.com/cleanup"}, DeleteTimestamp: now.Add(-2 * time.Hour)},
+			namespace:  metav1.ObjectMeta{Labels: map[string]string{"environment": "prod"}},
+			wantRule:   "unmatched",
+			wantAction: ActionAlert,
+			wantDue:    true,
+		},
+		{
+			name:       "namespace glob, labels and min age match",
+			obj:        metrics.StuckObject{Namespace: "team-a", GroupVersionResource: pods, Labels: map[string]string{"app": "web"}, DeleteTimestamp: now.Add(-48 * time.Hour)},
+			wantRule:   "old-team-objects",
+			wantAction: ActionForceDelete,
+			wantDue:    true,
+		},
+		{
+			name:       "younger than min age",
+			obj:        metrics.StuckObject{Namespace: "team-a", GroupVersionResource: pods, Labels: map[string]string{"app": "web"}, DeleteTimestamp: now.Add(-time.Hour)},
+			wantRule:   "unmatched",
+			wantAction: ActionAlert,
+			wantDue:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := policy.Evaluate(tt.obj, tt.namespace, now)
+			if decision.Rule != tt.wantRule || decision.Action != tt.wantAction || decision.Due != tt.wantDue {
+				t.Errorf("Expected rule %s, action %s, due %t, got %+v", tt.wantRule, tt.wantAction, tt.wantDue, decision)
+			}
+		})
+	}
+
+	if keys := policy.LabelKeys(); len(keys) != 1 || keys[0] != "app" {
+		t.Errorf("Expected label keys [app], got %v", keys)
+	}
+}
+
+func TestGlobsMatches(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		value    string
+		want     bool
+	}{
+		{[]string{"*"}, "example.com/cleanup", true},
+		{[]string{"example.com/*"}, "example.com/cleanup", true},
+		{[]string{"*.cert-manager.io"}, "finalizer.acme.cert-manager.io", true},
+		{[]string{"kubernetes.io/pv?-protection"}, "kubernetes.io/pvc-protection", true},
+		{[]string{"kubernetes.io/pvc-protection"}, "kubernetes.io/pv-protection", false},
+		{[]string{"example.com/*"}, "other.example.com/cleanup", false},
+		{nil, "anything", false},
+	}
+	for _, tt := range tests {
+		if got := CompileGlobs(tt.patterns).Matches(tt.value); got != tt.want {
+			t.Errorf("CompileGlobs(%v).Matches(%q) = %t, expected %t", tt.patterns, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluateAnnotations(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	policy.DefaultAfter = 72 * time.Hour
+
+	now := time.Now()
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	tests := []struct {
+		name       string
+		obj        metrics.StuckObject
+		namespace  metav1.ObjectMeta
+		wantAction string
+		wantAfter  time.Duration
+		wantDue    bool
+	}{
+		{
+			name:       "object skip",
+			obj:        metrics.StuckObject{Namespace: "team-a", GroupVersionResource: pods, Labels: map[string]string{"app": "web"}, Annotations: map[string]string{AnnotationSkip: "true"}, DeleteTimestamp: now.Add(-48 * time.Hour)},
+			wantAction: ActionIgnore,
+			wantDue:    true,
+		},
+		{
+			name:       "namespace skip",
+			obj:        metrics.StuckObject{Namespace: "team-a", GroupVersionResource: pods, DeleteTimestamp: now.Add(-48 * time.Hour)},
+			namespace:  metav1.ObjectMeta{Annotations: map[string]string{AnnotationSkip: "true"}},
+			wantAction: ActionIgnore,
+			wantDue:    true,
+		},
+		{
+			name:       "allow-force upgrades an alert",
+			obj:        metrics.StuckObject{Namespace: "other", GroupVersionResource: pods, Annotations: map[string]string{AnnotationAllowForce: "true"}, DeleteTimestamp: now.Add(-48 * time.Hour)},
+			wantAction: ActionForceDelete,
+			wantAfter:  72 * time.Hour,
+			wantDue:    false,
+		},
+		{
+			name:       "object delete-after wins over namespace",
+			obj:        metrics.StuckObject{Namespace: "other", GroupVersionResource: pods, Annotations: map[string]string{AnnotationDeleteAfter: "6h"}, DeleteTimestamp: now.Add(-7 * time.Hour)},
+			namespace:  metav1.ObjectMeta{Annotations: map[string]string{AnnotationAllowForce: "true", AnnotationDeleteAfter: "24h"}},
+			wantAction: ActionForceDelete,
+			wantAfter:  6 * time.Hour,
+			wantDue:    true,
+		},
+		{
+			name:       "allow-force never overrides an ignore rule",
+			obj:        metrics.StuckObject{Namespace: "other", GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}, Annotations: map[string]string{AnnotationAllowForce: "true", AnnotationDeleteAfter: "0s"}, DeleteTimestamp: now.Add(-48 * time.Hour)},
+			namespace:  metav1.ObjectMeta{Annotations: map[string]string{AnnotationAllowForce: "true"}},
+			wantAction: ActionIgnore,
+			wantDue:    true,
+		},
+		{
+			name:       "allow-force keeps finalizeNamespace",
+			obj:        metrics.StuckObject{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}, Annotations: map[string]string{AnnotationAllowForce: "true"}, DeleteTimestamp: now.Add(-3 * time.Hour)},
+			wantAction: ActionFinalizeNamespace,
+			wantAfter:  2 * time.Hour,
+			wantDue:    true,
+		},
+		{
+			name:       "delete-after without allow-force keeps an alert",
+			obj:        metrics.StuckObject{Namespace: "other", GroupVersionResource: pods, Annotations: map[string]string{AnnotationDeleteAfter: "6h"}, DeleteTimestamp: now.Add(-7 * time.Hour)},
+			wantAction: ActionAlert,
+			wantDue:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := policy.Evaluate(tt.obj, tt.namespace, now)
+			if decision.Action != tt.wantAction || decision.After != tt.wantAfter || decision.Due != tt.wantDue {
+				t.Errorf("Expected action %s after %s, due %t, got %+v", tt.wantAction, tt.wantAfter, tt.wantDue, decision)
+			}
+		})
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := DefaultPolicy(72 * time.Hour)
+	now := time.Now()
+
+	decision := policy.Evaluate(metrics.StuckObject{DeleteTimestamp: now.Add(-73 * time.Hour)}, metav1.ObjectMeta{}, now)
+	if decision.Action != ActionForceDelete || !decision.Due {
+		t.Errorf("Expected a due forceDelete, got %+v", decision)
+	}
+	decision = policy.Evaluate(metrics.StuckObject{DeleteTimestamp: now.Add(-time.Hour)}, metav1.ObjectMeta{}, now)
+	if decision.Due {
+		t.Errorf("Expected forceDelete not to be due yet, got %+v", decision)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	invalid := map[string]string{
+		"unknown action":     "rules:\n  - action: explode\n",
+		"missing finalizers": "rules:\n  - action: removeFinalizers\n",
+		"unknown field":      "rules:\n  - action: alert\n    after: 1h\n    bogus: true\n",
+		"invalid selector":   "rules:\n  - action: alert\n    match:\n      selector:\n        matchExpressions:\n          - {key: app, operator: Bogus}\n",
+	}
+	for name, data := range invalid {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package remediate
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// CircuitBreaker halts remediation when the number of objects eligible for remediation exceeds a threshold
+// or jumps by more than maxIncrease since the previous cycle. Once open it stays open until a cycle is
+// back within the threshold and within maxIncrease of the count before it opened.
+type CircuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int
+	maxIncrease int
+	previous    int
+	hasPrevious bool
+	open        bool
+	reason      string
+}
+
+// NewCircuitBreaker returns a closed circuit breaker. A threshold or maxIncrease of zero disables that check.
+func NewCircuitBreaker(threshold, maxIncrease int) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, maxIncrease: maxIncrease}
+}
+
+// Evaluate records the number of eligible objects in a cycle and returns whether remediation may proceed,
+// with the reason when it may not.
+func (b *CircuitBreaker) Evaluate(eligible int) (bool, string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var reason string
+	switch {
+	case b.threshold > 0 && eligible > b.threshold:
+		reason = fmt.Sprintf("%d objects are eligible for remediation, more than the threshold of %d", eligible, b.threshold)
+	case b.maxIncrease > 0 && b.hasPrevious && eligible-b.previous > b.maxIncrease:
+		reason = fmt.Sprintf("%d objects are eligible for remediation, up from %d, an increase of more than %d", eligible, b.previous, b.maxIncrease)
+	}
+
+	switch {
+	case reason != "" && !b.open:
+		logger.Errorf("Circuit breaker opened, halting remediation: %s", reason)
+		b.open = true
+		b.reason = reason
+	case reason == "" && b.open:
+		logger.Infof("Circuit breaker closed: %d objects are eligible for remediation", eligible)
+		b.open = false
+		b.reason = ""
+	}
+
+	// While open, keep comparing against the count from before the breaker opened, so a sharp
+	// increase does not become the new baseline.
+	if !b.open {
+		b.previous = eligible
+		b.hasPrevious = true
+	}
+	metrics.WriteRemediationCircuitOpen(b.open)
+	return !b.open, b.reason
+}
+
+// limits applies the rate limit and per-cycle cap to the actions of a plan.
+type limits struct {
+	limiter     flowcontrol.RateLimiter
+	maxPerCycle int
+}
+
+// newLimits returns the remediation limits. A perMinute or maxPerCycle of zero disables that limit.
+func newLimits(perMinute float64, burst, maxPerCycle int) *limits {
+	l := &limits{maxPerCycle: maxPerCycle}
+	if perMinute > 0 {
+		l.limiter = flowcontrol.NewTokenBucketRateLimiter(float32(perMinute/60), burst)
+	}
+	return l
+}
+
+// wait blocks until the rate limit allows another remediation.
+func (l *limits) wait() {
+	if l.limiter != nil {
+		l.limiter.Accept()
+	}
+}
+
+// changesCluster reports whether an action modifies the cluster and is therefore subject to the limits.
+func changesCluster(action *Action) bool {
+	return !action.DryRun && policy.Remediates(action.Action)
+}
+
+// eligibleCount returns the number of actions in the plan that would remove finalizers, delete objects or finalize namespaces.
+func eligibleCount(plan *Plan) int {
+	count := 0
+	for _, action := range plan.Actions {
+		if policy.Remediates(action.Action) {
+			count++
+		}
+	}
+	return count
+}
+
+// approvalIDs returns the approval request IDs of the actions in the plan that change the cluster.
+func approvalIDs(plan *Plan) map[string]bool {
+	ids := make(map[string]bool)
+	for _, action := range plan.Actions {
+		if changesCluster(&action) {
+			ids[string(action.Object.UID)] = true
+		}
+	}
+	return ids
+}
@@ -0,0 +1,42 @@
+package remediate
+
+import "testing"
+
+func TestCircuitBreaker(t *testing.T) {
+	breaker := NewCircuitBreaker(100, 20)
+
+	steps := []struct {
+		eligible    int
+		wantProceed bool
+	}{
+		{eligible: 10, wantProceed: true},
+		{eligible: 25, wantProceed: true},
+		// A jump of more than 20 since the previous cycle opens the breaker.
+		{eligible: 60, wantProceed: false},
+		// The baseline stays at 25 while open, so the breaker stays open.
+		{eligible: 60, wantProceed: false},
+		{eligible: 40, wantProceed: true},
+		// More than the threshold opens the breaker regardless of the previous cycle.
+		{eligible: 101, wantProceed: false},
+		{eligible: 50, wantProceed: true},
+	}
+
+	for i, step := range steps {
+		proceed, reason := breaker.Evaluate(step.eligible)
+		if proceed != step.wantProceed {
+			t.Errorf("Step %d with %d eligible: expected proceed %t, got %t (%s)", i, step.eligible, step.wantProceed, proceed, reason)
+		}
+		if !proceed && reason == "" {
+			t.Errorf("Step %d: expected a reason when halted", i)
+		}
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := NewCircuitBreaker(0, 0)
+	for _, eligible := range []int{1, 10000, 1} {
+		if proceed, reason := breaker.Evaluate(eligible); !proceed {
+			t.Errorf("Expected a disabled breaker to proceed with %d eligible, got %s", eligible, reason)
+		}
+	}
+}
@@ -0,0 +1,371 @@
+package remediate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/approval"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/audit"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/backup"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/config"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/scan"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+)
+
+var logger = logging.SetupLogging()
+
+// Results of an executed action, as recorded in the audit trail.
+const (
+	ResultSucceeded = audit.ResultSucceeded
+	ResultFailed    = audit.ResultFailed
+	ResultAlerted   = audit.ResultAlerted
+	ResultSkipped   = audit.ResultSkipped
+)
+
+// Action is a single remediation planned for a stuck object.
+type Action struct {
+	Object     metrics.StuckObject    `json:"object"`
+	Rule       string                 `json:"rule"`
+	Action     string                 `json:"action"`
+	Finalizers []string               `json:"finalizers,omitempty"`
+	Age        string                 `json:"age"`
+	Reason     string                 `json:"reason"`
+	DryRun     bool                   `json:"dryRun"`
+	Result     string                 `json:"result,omitempty"`
+	Outcome    *k8s.RemediationResult `json:"outcome,omitempty"`
+	Backup     string                 `json:"backup,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+// Plan is the set of remediation actions computed from the stuck objects at a point in time.
+type Plan struct {
+	GeneratedAt time.Time `json:"generatedAt"`
+	DryRun      bool      `json:"dryRun"`
+	// Halted explains why the circuit breaker halted remediation, if it did.
+	Halted  string   `json:"halted,omitempty"`
+	Actions []Action `json:"actions"`
+}
+
+// namespacesResource is the resource of the objects the finalizeNamespace action applies to.
+var namespacesResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+var (
+	lastPlan   Plan
+	lastPlanMu sync.Mutex
+)
+
+// BuildPlan evaluates the policy against the given stuck objects and returns the actions that are due.
+// namespaces holds the metadata of each namespace, used by namespace selectors and annotations.
+func BuildPlan(pol *policy.Policy, stuckObjects []metrics.StuckObject, namespaces map[string]metav1.ObjectMeta, now time.Time) Plan {
+	plan := Plan{
+		GeneratedAt: now,
+		DryRun:      config.CFG.DryRun,
+		Actions:     make([]Action, 0),
+	}
+
+	for _, obj := range stuckObjects {
+		age := now.Sub(obj.DeleteTimestamp)
+		decision := pol.Evaluate(obj, namespaces[obj.Namespace], now)
+		if decision.Action == policy.ActionIgnore {
+			logger.Debugf("Ignoring %s %s in namespace %s per rule %s", obj.Resource, obj.Name, obj.Namespace, decision.Rule)
+			continue
+		}
+		if !decision.Due {
+			logger.Debugf("Action %s of %s %s in namespace %s is not due until it has been deleting for %s", decision.Action, obj.Resource, obj.Name, obj.Namespace, decision.After)
+			continue
+		}
+		reason := "matched rule " + decision.Rule + " after " + decision.After.String()
+		if len(decision.Annotations) > 0 {
+			reason += ", overridden by " + strings.Join(decision.Annotations, ", ")
+		}
+		plan.Actions = append(plan.Actions, Action{
+			Object:     obj,
+			Rule:       decision.Rule,
+			Action:     decision.Action,
+			Finalizers: decision.Finalizers,
+			Age:        age.Round(time.Second).String(),
+			Reason:     reason,
+			DryRun:     config.CFG.DryRun || decision.DryRun,
+		})
+	}
+
+	return plan
+}
+
+// Remediator executes remediation plans, backing up every object before changing it.
+type Remediator struct {
+	clientset      k8s.ClientsetInterface
+	dynamicClient  dynamic.Interface
+	metadataClient metadata.Interface
+	policy         *policy.Policy
+	backups        backup.Backend
+	auditor        *audit.Auditor
+	approvals      *approval.Queue
+	breaker        *CircuitBreaker
+	limits         *limits
+	// removable and protected are the compiled removable and protected finalizer globs of the configuration.
+	removable policy.Globs
+	protected policy.Globs
+	// runMu serializes Run, which is called by the cleanup cycle and after each approval.
+	runMu sync.Mutex
+}
+
+// NewRemediator returns a remediator applying the policy through the given clients and recording every action
+// with the auditor. backups may be nil to disable backups. When approvals is set, finalizer removals and deletions only run once approved.
+func NewRemediator(clientset k8s.ClientsetInterface, dynamicClient dynamic.Interface, metadataClient metadata.Interface, pol *policy.Policy, backups backup.Backend, auditor *audit.Auditor, approvals *approval.Queue) *Remediator {
+	return &Remediator{
+		clientset:      clientset,
+		dynamicClient:  dynamicClient,
+		metadataClient: metadataClient,
+		policy:         pol,
+		backups:        backups,
+		auditor:        auditor,
+		approvals:      approvals,
+		breaker:        NewCircuitBreaker(config.CFG.CircuitBreakerThreshold, config.CFG.CircuitBreakerMaxIncrease),
+		limits:         newLimits(config.CFG.RemediationsPerMinute, config.CFG.RemediationBurst, config.CFG.MaxRemediationsPerCycle),
+		removable:      policy.CompileGlobs(config.CFG.RemovableFinalizers),
+		protected:      policy.CompileGlobs(config.CFG.ProtectedFinalizers),
+	}
+}
+
+// Execute carries out every action in the plan, recording the result on each action.
+// Actions marked as dry-run are sent with server-side dry-run and change nothing. Actions that change the
+// cluster are rate limited and capped per cycle, and all but alerts are skipped while the circuit breaker is open.
+// When approvals are required, actions that change the cluster wait in the approval queue until approved.
+func (r *Remediator) Execute(plan *Plan) {
+	proceed, halted := r.breaker.Evaluate(eligibleCount(plan))
+	plan.Halted = halted
+	executed := 0
+	if r.approvals != nil {
+		r.approvals.Prune(approvalIDs(plan))
+	}
+
+	for i := range plan.Actions {
+		action := &plan.Actions[i]
+		obj := action.Object
+
+		if action.Action != policy.ActionAlert {
+			if !proceed {
+				r.skip(action, "circuit breaker open: "+halted)
+				continue
+			}
+			if changesCluster(action) && r.approvals != nil {
+				if approved, reason := r.approvals.Check(obj, action.Action, action.Rule, action.Reason, time.Now()); !approved {
+					r.skip(action, reason)
+					continue
+				}
+			}
+			if changesCluster(action) {
+				if r.limits.maxPerCycle > 0 && executed >= r.limits.maxPerCycle {
+					r.skip(action, fmt.Sprintf("limit of %d remediations per cycle reached", r.limits.maxPerCycle))
+					continue
+				}
+				r.limits.wait()
+				executed++
+			}
+		}
+
+		var outcome k8s.RemediationResult
+		var err error
+		switch action.Action {
+		case policy.ActionAlert:
+			logger.Warnf("Stuck object %s of resource %s in namespace %s has been deleting for %s (rule %s)", obj.Name, obj.Resource, obj.Namespace, action.Age, action.Rule)
+			action.Result = ResultAlerted
+			r.record(action)
+			continue
+		case policy.ActionRemoveFinalizers:
+			shouldRemove := r.removableFinalizer(action.Finalizers)
+			if err = r.backupObject(action, shouldRemove); err == nil {
+				outcome, err = k8s.RemoveFinalizers(r.dynamicClient, obj.Namespace, obj.GroupVersionResource, obj.Name, shouldRemove, action.DryRun)
+			}
+		case policy.ActionForceDelete:
+			shouldRemove := r.removableFinalizer(nil)
+			if err = r.backupObject(action, shouldRemove); err == nil {
+				outcome, err = k8s.ForceDeleteOldResource(r.dynamicClient, obj.Namespace, obj.GroupVersionResource, obj.Name, shouldRemove, action.DryRun)
+			}
+		case policy.ActionFinalizeNamespace:
+			var remaining []string
+			if remaining, err = r.namespaceContents(obj); err == nil && len(remaining) > 0 {
+				r.skip(action, "namespace still contains "+strings.Join(remaining, ", "))
+				continue
+			}
+			if err == nil {
+				// The namespace's metadata finalizers are left alone, only its spec finalizers are cleared.
+				if err = r.backupObject(action, nil); err == nil {
+					outcome, err = k8s.FinalizeNamespace(r.clientset, obj.Name, r.removableFinalizer(action.Finalizers), action.DryRun)
+				}
+			}
+		}
+		action.Outcome = &outcome
+
+		if err != nil {
+			logger.Errorf("Error running %s on resource %s in namespace %s: %v", action.Action, obj.Name, obj.Namespace, err)
+			action.Result = ResultFailed
+			action.Error = err.Error()
+		} else {
+			logger.Infof("Successfully ran %s on resource %s in namespace %s (dry-run: %t)", action.Action, obj.Name, obj.Namespace, action.DryRun)
+			action.Result = ResultSucceeded
+			if r.approvals != nil && changesCluster(action) {
+				r.approvals.Complete(obj)
+			}
+		}
+		r.record(action)
+	}
+}
+
+// skip records that an action was not executed and why.
+func (r *Remediator) skip(action *Action, reason string) {
+	logger.Warnf("Skipping %s of %s %s in namespace %s: %s", action.Action, action.Object.Resource, action.Object.Name, action.Object.Namespace, reason)
+	action.Result = ResultSkipped
+	action.Error = reason
+	r.record(action)
+}
+
+// record counts the result of an action in the metrics and appends it to the audit trail.
+func (r *Remediator) record(action *Action) {
+	metrics.RecordRemediation(action.Action, action.DryRun, action.Result)
+
+	obj := action.Object
+	entry := audit.Entry{
+		Time:                 time.Now(),
+		Action:               action.Action,
+		Rule:                 action.Rule,
+		Reason:               action.Reason,
+		DryRun:               action.DryRun,
+		Result:               action.Result,
+		Error:                action.Error,
+		GroupVersionResource: obj.GroupVersionResource,
+		Namespace:            obj.Namespace,
+		Name:                 obj.Name,
+		UID:                  obj.UID,
+		Age:                  action.Age,
+		Finalizers:           obj.Finalizers,
+		Backup:               action.Backup,
+	}
+	if action.Outcome != nil {
+		entry.RemovedFinalizers = action.Outcome.RemovedFinalizers
+		entry.Deleted = action.Outcome.Deleted
+	}
+	r.auditor.Record(entry)
+}
+
+// backupObject snapshots the full object of an action before it is changed, recording where the backup was written
+// and which of its metadata finalizers shouldRemove selects for removal. Dry-run actions change nothing and are
+// not backed up. An object that is already gone needs no backup.
+func (r *Remediator) backupObject(action *Action, shouldRemove func(finalizer string) bool) error {
+	if r.backups == nil || action.DryRun {
+		return nil
+	}
+
+	obj := action.Object
+	current, err := k8s.GetObject(r.dynamicClient, obj.Namespace, obj.GroupVersionResource, obj.Name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error fetching object to back up: %v", err)
+	}
+
+	var removed []string
+	for _, finalizer := range current.GetFinalizers() {
+		if shouldRemove != nil && shouldRemove(finalizer) {
+			removed = append(removed, finalizer)
+		}
+	}
+	location, err := r.backups.Save(backup.NewBackup(current, obj.GroupVersionResource, action.Action, action.Rule, removed, time.Now()))
+	if err != nil {
+		return fmt.Errorf("error backing up object, not remediating: %v", err)
+	}
+	logger.Infof("Backed up %s %s in namespace %s to %s", obj.Resource, obj.Name, obj.Namespace, location)
+	action.Backup = location
+	return nil
+}
+
+// namespaceContents returns the resources that still have objects in the namespace of a finalizeNamespace
+// action, so the finalizers are only cleared once the namespace is truly empty.
+func (r *Remediator) namespaceContents(obj metrics.StuckObject) ([]string, error) {
+	if obj.GroupVersionResource != namespacesResource {
+		return nil, fmt.Errorf("action %s only applies to namespaces, not %s", policy.ActionFinalizeNamespace, obj.GroupVersionResource.GroupResource())
+	}
+	remaining, err := scan.NamespaceContents(r.clientset, r.metadataClient, obj.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying namespace %s is empty: %v", obj.Name, err)
+	}
+	return remaining, nil
+}
+
+// removableFinalizer returns whether remediation may remove a finalizer: it must be on the removable allow-list,
+// not protected and, when targets is set, match one of the targets.
+func (r *Remediator) removableFinalizer(targets []string) func(finalizer string) bool {
+	targetGlobs := policy.CompileGlobs(targets)
+	return func(finalizer string) bool {
+		if len(targetGlobs) > 0 && !targetGlobs.Matches(finalizer) {
+			return false
+		}
+		return r.removable.Matches(finalizer) && !r.protected.Matches(finalizer)
+	}
+}
+
+// Run plans remediation for the current stuck objects according to the policy, logs and publishes the plan, then executes it.
+func (r *Remediator) Run() Plan {
+	r.runMu.Lock()
+	defer r.runMu.Unlock()
+
+	namespaces, err := k8s.GetNamespaceMetadata(r.clientset)
+	if err != nil {
+		logger.Errorf("Error fetching namespaces, namespace selectors and annotations will not apply: %v", err)
+	}
+
+	plan := BuildPlan(r.policy, metrics.GetStuckObjects(), namespaces, time.Now())
+	logPlan(plan)
+
+	counts := make(map[string]int)
+	for _, action := range plan.Actions {
+		counts[action.Action]++
+	}
+	metrics.WriteRemediationPlan(counts, plan.DryRun)
+
+	r.Execute(&plan)
+
+	lastPlanMu.Lock()
+	lastPlan = plan
+	lastPlanMu.Unlock()
+	return plan
+}
+
+// logPlan logs every action in the plan.
+func logPlan(plan Plan) {
+	logger.Infof("Remediation plan has %d actions (dry-run: %t)", len(plan.Actions), plan.DryRun)
+	for _, action := range plan.Actions {
+		logger.Infof("Planned %s of %s %s in namespace %s: %s, age %s (dry-run: %t)", action.Action, action.Object.Resource, action.Object.Name, action.Object.Namespace, action.Reason, action.Age, action.DryRun)
+	}
+}
+
+// GetLastPlan returns the most recently executed remediation plan.
+func GetLastPlan() Plan {
+	lastPlanMu.Lock()
+	defer lastPlanMu.Unlock()
+	return lastPlan
+}
+
+// PlanHandler returns the most recent remediation plan and its results as JSON.
+func PlanHandler(w http.ResponseWriter, r *http.Request) {
+	logger.Debug("Handling request for remediation plan")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(GetLastPlan()); err != nil {
+		logger.Errorf("Failed to encode remediation plan: %v", err)
+		http.Error(w, "Failed to encode remediation plan", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,57 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/metadata"
+)
+
+// NamespaceContents lists every listable namespaced resource in a namespace and returns the group/resources
+// that still have objects in it. An empty result means the namespace is truly empty. Discovery must succeed
+// for every group, since a resource that could not be listed may still hold objects.
+func NamespaceContents(clientset k8s.ClientsetInterface, metadataClient metadata.Interface, namespace string) ([]string, error) {
+	logger.Debugf("Checking whether namespace %s is empty", namespace)
+
+	groups, resourceLists, err := clientset.Discovery().ServerGroupsAndResources()
+	if err != nil {
+		return nil, fmt.Errorf("error discovering namespaced resources: %v", err)
+	}
+	// Objects are served by every version of their group, so only the preferred version is listed.
+	preferred := make(map[string]bool)
+	for _, group := range groups {
+		preferred[group.PreferredVersion.GroupVersion] = true
+	}
+	listable := discovery.FilteredBy(discovery.ResourcePredicateFunc(func(groupVersion string, resource *metav1.APIResource) bool {
+		return preferred[groupVersion] && resource.Namespaced && discovery.SupportsAllVerbs{Verbs: []string{"list"}}.Match(groupVersion, resource)
+	}), resourceLists)
+	resources, err := discovery.GroupVersionResources(listable)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing namespaced resources: %v", err)
+	}
+
+	var remaining []string
+	for resource := range resources {
+		if shouldIgnoreGroup(resource.GroupVersion().String()) {
+			continue
+		}
+		objects, err := metadataClient.Resource(resource).Namespace(namespace).List(context.Background(), metav1.ListOptions{Limit: 1})
+		if isResourceNotFoundError(err) {
+			logger.Debugf("Resource %s not found while checking namespace %s", resource.Resource, namespace)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s in namespace %s: %v", resource.GroupResource(), namespace, err)
+		}
+		if len(objects.Items) > 0 {
+			remaining = append(remaining, resource.GroupResource().String())
+		}
+	}
+
+	sort.Strings(remaining)
+	return remaining, nil
+}
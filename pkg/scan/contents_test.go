@@ -0,0 +1,50 @@
+package scan
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubernetesfake "k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+)
+
+func TestNamespaceContents(t *testing.T) {
+	clientset := kubernetesfake.NewSimpleClientset()
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: "v1",
+		APIResources: []metav1.APIResource{
+			{Name: "pods", Kind: "Pod", Namespaced: true, Verbs: metav1.Verbs{"list", "get"}},
+			{Name: "configmaps", Kind: "ConfigMap", Namespaced: true, Verbs: metav1.Verbs{"list", "get"}},
+			// Resources that cannot be listed are not checked.
+			{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: metav1.Verbs{"create"}},
+			{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"list", "get"}},
+		},
+	}}
+
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	metadataClient := metadatafake.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+			ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "pod"},
+		},
+	)
+
+	remaining, err := NamespaceContents(clientset, metadataClient, "team-a")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(remaining) != 1 || remaining[0] != "pods" {
+		t.Errorf("Expected pods to remain in team-a, got %v", remaining)
+	}
+
+	remaining, err = NamespaceContents(clientset, metadataClient, "team-b")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(remaining) != 0 {
+		t.Errorf("Expected team-b to be empty, got %v", remaining)
+	}
+}
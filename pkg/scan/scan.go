@@ -4,14 +4,21 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/config"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
 	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 )
 
@@ -19,21 +26,31 @@ var logger = logging.SetupLogging()
 
 // StartScan initiates a scan of the Kubernetes cluster to find resources that are stuck in a deletion state.
 func StartScan(clientset *kubernetes.Clientset, restConfig *rest.Config) (bool, int, int, error) {
-	start := time.Now()  // Start time for the scan
-	var totalObjects int // Counter for total objects scanned
+	start := time.Now()           // Start time for the scan
+	var totalObjects atomic.Int64 // Counter for total objects scanned, shared by the workers
 
 	logger.Infoln("Starting scan...")
 
 	logger.Debugln("Verifying access to cluster")
 	if err := k8s.VerifyAccessToCluster(clientset); err != nil {
-		logger.Fatalf("Error verifying access to cluster: %v", err)
+		logger.Errorf("Error verifying access to cluster: %v", err)
 		return false, 0, 0, fmt.Errorf("error verifying access to cluster: %v", err)
 	}
 
+	// API groups that fail discovery are recorded and skipped, so one broken aggregated API does not stop the scan.
+	failedGroups := make(map[schema.GroupVersion]error)
+	discoveryFailed := func(err error) bool {
+		groups := k8s.FailedGroups(err)
+		for groupVersion, groupErr := range groups {
+			failedGroups[groupVersion] = groupErr
+		}
+		return groups != nil
+	}
+
 	logger.Infoln("Fetching core namespaced resources...")
 	coreResources, err := GetCoreResources(clientset)
-	if err != nil {
-		logger.Fatalf("Error fetching core resources: %v", err)
+	if err != nil && !discoveryFailed(err) {
+		logger.Errorf("Error fetching core resources: %v", err)
 		return false, 0, 0, err
 	}
 
@@ -41,13 +58,27 @@ func StartScan(clientset *kubernetes.Clientset, restConfig *rest.Config) (bool,
 
 	logger.Infoln("Fetching custom namespaced resources...")
 	namespacedResources, err := k8s.GetNamespacedObjects(clientset)
-	if err != nil {
-		logger.Fatalf("Error fetching namespaced resources: %v", err)
+	if err != nil && !discoveryFailed(err) {
+		logger.Errorf("Error fetching namespaced resources: %v", err)
 		return false, 0, 0, err
 	}
 
 	logger.Infof("Found %d namespaced custom resources: %v", len(namespacedResources), namespacedResources)
 
+	logger.Infoln("Fetching cluster-scoped resources...")
+	clusterScopedResources, err := k8s.GetClusterScopedObjects(clientset)
+	if err != nil && !discoveryFailed(err) {
+		logger.Errorf("Error fetching cluster-scoped resources: %v", err)
+		return false, 0, 0, err
+	}
+
+	logger.Infof("Found %d cluster-scoped resources: %v", len(clusterScopedResources), clusterScopedResources)
+
+	metrics.WriteDiscoveryFailures(failedGroups)
+	for groupVersion, groupErr := range failedGroups {
+		logger.Warnf("Skipping API group %s, which failed discovery: %v", groupVersion, groupErr)
+	}
+
 	logger.Infoln("Fetching namespaces...")
 	namespaces, err := k8s.GetNamespaces(clientset)
 	if err != nil {
@@ -57,40 +88,109 @@ func StartScan(clientset *kubernetes.Clientset, restConfig *rest.Config) (bool,
 
 	logger.Infof("Found %d namespaces", len(namespaces))
 
+	skippedNamespaces, err := getSkippedNamespaces(clientset)
+	if err != nil {
+		logger.Errorf("Error fetching namespace annotations: %v", err)
+		return false, 0, 0, err
+	}
+
+	// A single metadata client is shared by every LIST request in the scan, so only ObjectMeta is downloaded.
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		logger.Errorf("Error creating metadata client: %v", err)
+		return false, 0, 0, fmt.Errorf("error creating metadata client: %v", err)
+	}
+
 	// Update the number of namespaces metric
 	metrics.WriteNamespaceCount(len(namespaces))
 
-	for _, ns := range namespaces {
-		logger.Debugf("Processing core resources in namespace %s", ns)
-		coreObjects, err := processNamespace(restConfig, ns, coreResources)
+	// Core resources are also returned by GetNamespacedObjects, so merge the lists to list each resource once.
+	resources := mergeResources(coreResources, namespacedResources)
+	logger.Infof("Scanning %d namespaced and %d cluster-scoped resources", len(resources), len(clusterScopedResources))
+
+	// Objects not seen again during this scan are recorded as resolved, except for resources that
+	// could not be fully listed.
+	metrics.BeginScan()
+	var resultsMu sync.Mutex
+	var forbidden []schema.GroupVersionResource
+	failed := make(map[schema.GroupVersionResource]bool)
+	markFailed := func(resource schema.GroupVersionResource) {
+		resultsMu.Lock()
+		failed[resource] = true
+		resultsMu.Unlock()
+	}
+
+	// List every resource cluster-wide, collecting the namespaced ones RBAC only allows reading per namespace.
+	clusterUnits := make([]scanUnit, 0, len(resources)+len(clusterScopedResources))
+	for _, resource := range resources {
+		clusterUnits = append(clusterUnits, scanUnit{scope: metrics.ScopeNamespaced, resource: resource, namespace: metav1.NamespaceAll})
+	}
+	for _, resource := range clusterScopedResources {
+		clusterUnits = append(clusterUnits, scanUnit{scope: metrics.ScopeCluster, resource: resource})
+	}
+	runWorkers(config.CFG.ScanWorkers, clusterUnits, func(unit scanUnit) {
+		objects, err := processUnit(metadataClient, unit, skippedNamespaces)
+		if errors.IsForbidden(err) && unit.scope == metrics.ScopeNamespaced {
+			logger.Infof("Listing resource %s across all namespaces is forbidden, falling back to per-namespace listing", unit.resource.Resource)
+			resultsMu.Lock()
+			forbidden = append(forbidden, unit.resource)
+			resultsMu.Unlock()
+			return
+		}
 		if err != nil {
-			logger.Errorf("Error processing core resources in namespace %s: %v", ns, err)
-			continue
+			logger.Errorf("Error processing resource %s: %v", unit.resource.Resource, err)
+			markFailed(unit.resource)
+			return
 		}
-		totalObjects += coreObjects
+		totalObjects.Add(int64(objects))
+	})
+
+	// Fall back to one LIST per namespace for the resources that could not be listed cluster-wide.
+	if len(forbidden) > 0 {
+		namespaceUnits := make([]scanUnit, 0, len(forbidden)*len(namespaces))
+		for _, resource := range forbidden {
+			for _, ns := range namespaces {
+				namespaceUnits = append(namespaceUnits, scanUnit{scope: metrics.ScopeNamespaced, resource: resource, namespace: ns})
+			}
+		}
+		runWorkers(config.CFG.ScanWorkers, namespaceUnits, func(unit scanUnit) {
+			objects, err := processUnit(metadataClient, unit, skippedNamespaces)
+			if errors.IsForbidden(err) {
+				logger.Debugf("Skipping resource %s in namespace %s: %v", unit.resource.Resource, unit.namespace, err)
+				markFailed(unit.resource)
+				return
+			}
+			if err != nil {
+				logger.Errorf("Error processing resource %s in namespace %s: %v", unit.resource.Resource, unit.namespace, err)
+				markFailed(unit.resource)
+				return
+			}
+			totalObjects.Add(int64(objects))
+		})
+	}
 
-		logger.Debugf("Processing custom resources in namespace %s", ns)
-		customObjects, err := processNamespace(restConfig, ns, namespacedResources)
-		if err != nil {
-			logger.Errorf("Error processing custom resources in namespace %s: %v", ns, err)
-			continue
+	// Stuck objects of groups that failed discovery could not be listed, so they are kept rather than resolved.
+	for _, stuckObject := range metrics.GetStuckObjects() {
+		if _, ok := failedGroups[stuckObject.GroupVersionResource.GroupVersion()]; ok {
+			failed[stuckObject.GroupVersionResource] = true
 		}
-		totalObjects += customObjects
 	}
+	metrics.EndScan(failed)
 
 	// Record the scan metrics
-	metrics.RecordScanMetrics(start, len(namespaces), totalObjects)
+	metrics.RecordScanMetrics(start, len(namespaces), int(totalObjects.Load()))
 
-	return true, len(namespaces), totalObjects, nil
+	return true, len(namespaces), int(totalObjects.Load()), nil
 }
 
 // GetCoreResources fetches the core namespaced resources available in the cluster.
+// When some API groups fail discovery, the core resources are returned with the discovery error.
 func GetCoreResources(clientset *kubernetes.Clientset) ([]schema.GroupVersionResource, error) {
 	discoveryClient := clientset.Discovery()
-	resourceList, err := discoveryClient.ServerPreferredResources()
-	if err != nil {
-		logger.Errorf("Error fetching server resources: %v", err)
-		return nil, fmt.Errorf("error fetching server resources: %v", err)
+	resourceList, discoveryErr := discoveryClient.ServerPreferredResources()
+	if discoveryErr != nil && !discovery.IsGroupDiscoveryFailedError(discoveryErr) {
+		logger.Errorf("Error fetching server resources: %v", discoveryErr)
+		return nil, fmt.Errorf("error fetching server resources: %v", discoveryErr)
 	}
 
 	var coreResources []schema.GroupVersionResource
@@ -112,66 +212,108 @@ func GetCoreResources(clientset *kubernetes.Clientset) ([]schema.GroupVersionRes
 		}
 	}
 
-	return coreResources, nil
+	return coreResources, discoveryErr
 }
 
-// processNamespace processes all resources in a given namespace.
-func processNamespace(restConfig *rest.Config, ns string, resources []schema.GroupVersionResource) (int, error) {
-	logger.Infof("Processing namespace %s", ns)
-
-	totalObjects := 0
-
-	for _, resource := range resources {
-		logger.Debugf("Processing resource %s in namespace %s", resource.Resource, ns)
-		objects, err := processResource(restConfig, ns, resource)
-		if err != nil {
-			logger.Errorf("Error processing resource %s in namespace %s: %v", resource.Resource, ns, err)
-			continue
+// mergeResources combines resource lists, dropping duplicates while preserving order.
+func mergeResources(lists ...[]schema.GroupVersionResource) []schema.GroupVersionResource {
+	seen := make(map[schema.GroupVersionResource]bool)
+	var merged []schema.GroupVersionResource
+	for _, list := range lists {
+		for _, resource := range list {
+			if seen[resource] {
+				continue
+			}
+			seen[resource] = true
+			merged = append(merged, resource)
 		}
-		totalObjects += objects
 	}
-
-	return totalObjects, nil
+	return merged
 }
 
-// processResource processes all objects of a given resource type in a namespace.
-func processResource(restConfig *rest.Config, ns string, resource schema.GroupVersionResource) (int, error) {
-	logger.Infof("Processing resource %s", resource.Resource)
+// processUnit lists all objects of a resource in the unit's namespace, or across the cluster
+// when the namespace is metav1.NamespaceAll or the resource is cluster-scoped, and processes them by namespace.
+// Objects in skipped namespaces are counted but not processed, and stop being reported as stuck.
+func processUnit(metadataClient metadata.Interface, unit scanUnit, skippedNamespaces map[string]bool) (int, error) {
+	logger.Debugf("Processing resource %s in namespace %q", unit.resource.Resource, unit.namespace)
 
-	objects, err := k8s.GetNamespaceObjects(restConfig, ns, resource)
+	objects, err := k8s.GetNamespaceObjects(metadataClient, unit.namespace, unit.resource, int64(config.CFG.PageSize))
 	if err != nil {
 		if isResourceNotFoundError(err) {
-			logger.Warnf("Resource %s not found in namespace %s", resource.Resource, ns)
+			logger.Warnf("Resource %s not found in namespace %q", unit.resource.Resource, unit.namespace)
 			return 0, nil
 		}
-		logger.Errorf("Error fetching objects for resource %s in namespace %s: %v", resource.Resource, ns, err)
 		return 0, err
 	}
 
-	logger.Infof("Found %d objects for resource %s in namespace %s", len(objects), resource.Resource, ns)
-	for _, object := range objects {
-		logger.Debugf("Processing object %s of resource %s in namespace %s", object, resource.Resource, ns)
-		processObject(restConfig, ns, resource, object)
+	byNamespace := bucketByNamespace(objects)
+	logger.Infof("Found %d objects for resource %s in %d namespaces", len(objects), unit.resource.Resource, len(byNamespace))
+	for ns, nsObjects := range byNamespace {
+		if skippedNamespaces[ns] {
+			logger.Debugf("Skipping %d objects of resource %s in namespace %s annotated with %s", len(nsObjects), unit.resource.Resource, ns, policy.AnnotationSkip)
+			for _, object := range nsObjects {
+				if object.GetDeletionTimestamp() != nil {
+					metrics.SkipStuckObject(object.GetUID())
+				}
+			}
+			continue
+		}
+		logger.Debugf("Processing %d objects of resource %s in namespace %s", len(nsObjects), unit.resource.Resource, ns)
+		for _, object := range nsObjects {
+			processObject(unit.scope, ns, unit.resource, object)
+		}
 	}
 
 	return len(objects), nil
 }
 
-// processObject processes a single object, checking if it is deleted and recording it if it is stuck.
-func processObject(restConfig *rest.Config, ns string, resource schema.GroupVersionResource, object string) {
-	logger.Infof("Processing object %s", object)
-	isDeleted, deletionTimestamp, err := k8s.IsObjectDeleted(restConfig, ns, resource, object)
+// getSkippedNamespaces returns the namespaces annotated to be skipped.
+func getSkippedNamespaces(clientset *kubernetes.Clientset) (map[string]bool, error) {
+	namespaces, err := k8s.GetNamespaceMetadata(clientset)
 	if err != nil {
-		logger.Errorf("Error checking if object %s is deleted: %v", object, err)
+		return nil, err
+	}
+	skipped := make(map[string]bool)
+	for name, namespace := range namespaces {
+		if policy.IsSkipped(namespace.GetAnnotations(), nil) {
+			logger.Infof("Skipping namespace %s annotated with %s", name, policy.AnnotationSkip)
+			skipped[name] = true
+		}
+	}
+	return skipped, nil
+}
+
+// bucketByNamespace groups objects by the namespace they belong to.
+func bucketByNamespace(objects []metav1.PartialObjectMetadata) map[string][]*metav1.PartialObjectMetadata {
+	byNamespace := make(map[string][]*metav1.PartialObjectMetadata)
+	for i := range objects {
+		ns := objects[i].GetNamespace()
+		byNamespace[ns] = append(byNamespace[ns], &objects[i])
+	}
+	return byNamespace
+}
+
+// processObject processes a single object, recording it if it has been deleting for longer than the stuck threshold.
+func processObject(scope, ns string, resource schema.GroupVersionResource, object metav1.Object) {
+	logger.Debugf("Processing object %s", object.GetName())
+	isDeleted, deletionTimestamp := k8s.IsObjectDeleted(object)
+	if !isDeleted {
 		return
 	}
 
-	if isDeleted {
-		logger.Infof("Object %s is deleted", object)
-		metrics.AddStuckObject(ns, resource, object, deletionTimestamp)
-	} else {
-		logger.Infof("Object %s is not deleted", object)
+	if policy.IsSkipped(object.GetAnnotations(), nil) {
+		logger.Debugf("Skipping object %s in namespace %s annotated with %s", object.GetName(), ns, policy.AnnotationSkip)
+		metrics.SkipStuckObject(object.GetUID())
+		return
 	}
+
+	if !IsStuck(resource, object, time.Now()) {
+		logger.Debugf("Object %s in namespace %s has been deleting since %s, within the %s threshold", object.GetName(), ns, deletionTimestamp, StuckAfter(resource))
+		return
+	}
+
+	logger.Infof("Object %s in namespace %s is stuck deleting since %s", object.GetName(), ns, deletionTimestamp)
+	metrics.AddStuckObject(scope, resource, object)
 }
 
 // isResourceNotFoundError checks if the error returned is a "resource not found" error.
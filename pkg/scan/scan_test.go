@@ -0,0 +1,59 @@
+package scan
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	metadatafake "k8s.io/client-go/metadata/fake"
+)
+
+func TestSkippedObjectsAreNotResolved(t *testing.T) {
+	pods := schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	deletionTimestamp := metav1.NewTime(time.Now().Add(-time.Hour))
+	object := &metav1.ObjectMeta{Namespace: "default", Name: "skipped-pod", UID: "skipped-uid", DeletionTimestamp: &deletionTimestamp, Finalizers: []string{"example.com/cleanup"}}
+	inSkippedNamespace := &metav1.PartialObjectMetadata{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: "skipped", Name: "other-pod", UID: "other-uid", DeletionTimestamp: &deletionTimestamp},
+	}
+	scheme := metadatafake.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	metadataClient := metadatafake.NewSimpleMetadataClient(scheme, inSkippedNamespace)
+	metrics.AddStuckObject(metrics.ScopeNamespaced, pods, object)
+	metrics.AddStuckObject(metrics.ScopeNamespaced, pods, inSkippedNamespace)
+
+	// The object and the namespace of the other are annotated to be skipped while they are still stuck.
+	object.Annotations = map[string]string{policy.AnnotationSkip: "true"}
+	metrics.BeginScan()
+	processObject(metrics.ScopeNamespaced, "default", pods, object)
+	if _, err := processUnit(metadataClient, scanUnit{scope: metrics.ScopeNamespaced, resource: pods, namespace: metav1.NamespaceAll}, map[string]bool{"skipped": true}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	metrics.EndScan(nil)
+
+	for _, stuckObject := range metrics.GetStuckObjects() {
+		if stuckObject.UID == "skipped-uid" || stuckObject.UID == "other-uid" {
+			t.Errorf("Expected skipped object %s to no longer be reported as stuck", stuckObject.Name)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	metrics.GetResolvedObjectsHandler(recorder, httptest.NewRequest(http.MethodGet, "/resolved-objects", nil))
+	var resolved []metrics.ResolvedObject
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resolved); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, resolvedObject := range resolved {
+		if resolvedObject.UID == "skipped-uid" || resolvedObject.UID == "other-uid" {
+			t.Errorf("Expected skipped object %s not to be reported as resolved", resolvedObject.Name)
+		}
+	}
+}
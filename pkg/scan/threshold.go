@@ -0,0 +1,35 @@
+package scan
+
+import (
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/config"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// StuckAfter returns how long an object of the given resource must have been deleting before it counts as stuck.
+// Overrides are keyed by group/resource, e.g. "pods" or "deployments.apps".
+func StuckAfter(resource schema.GroupVersionResource) time.Duration {
+	if threshold, ok := config.CFG.StuckAfterOverrides[resource.GroupResource().String()]; ok {
+		return threshold
+	}
+	return config.CFG.StuckAfter
+}
+
+// StuckSince returns the time at which a deleting object starts counting as stuck, and false if it is not deleting.
+// For graceful deletions the API server sets deletionTimestamp to the request time plus deletionGracePeriodSeconds,
+// so the threshold only starts once the grace period has elapsed.
+func StuckSince(resource schema.GroupVersionResource, object metav1.Object) (time.Time, bool) {
+	deletionTimestamp := object.GetDeletionTimestamp()
+	if deletionTimestamp == nil {
+		return time.Time{}, false
+	}
+	return deletionTimestamp.Add(StuckAfter(resource)), true
+}
+
+// IsStuck reports whether an object has been deleting for longer than its resource's threshold.
+func IsStuck(resource schema.GroupVersionResource, object metav1.Object, now time.Time) bool {
+	stuckSince, isDeleting := StuckSince(resource, object)
+	return isDeleting && now.After(stuckSince)
+}
@@ -0,0 +1,43 @@
+package scan
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// scanUnit is a single LIST of a resource, either in one namespace or across the cluster.
+type scanUnit struct {
+	scope     string
+	resource  schema.GroupVersionResource
+	namespace string
+}
+
+// runWorkers processes units with a bounded number of concurrent workers and waits for all of them to finish.
+func runWorkers(workers int, units []scanUnit, process func(scanUnit)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(units) {
+		workers = len(units)
+	}
+	logger.Debugf("Processing %d scan units with %d workers", len(units), workers)
+
+	unitCh := make(chan scanUnit)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for unit := range unitCh {
+				process(unit)
+			}
+		}()
+	}
+
+	for _, unit := range units {
+		unitCh <- unit
+	}
+	close(unitCh)
+	wg.Wait()
+}
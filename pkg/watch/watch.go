@@ -0,0 +1,329 @@
+package watch
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/k8s"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/logging"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/metrics"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/policy"
+	"github.com/mattmattox/k8s-deletion-inspector/pkg/scan"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+)
+
+var logger = logging.SetupLogging()
+
+// resyncPeriod is how often informers replay their cache so objects are re-evaluated.
+const resyncPeriod = 10 * time.Minute
+
+// crdResource is the resource watched to detect when the informer set needs refreshing.
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// namespacesResource is the resource whose informer cache provides namespace annotations.
+var namespacesResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// Watcher records objects into the stuck set as soon as their deletion exceeds the stuck threshold,
+// using a metadata-only informer for every namespaced and cluster-scoped resource discovered in the cluster.
+type Watcher struct {
+	clientset      *kubernetes.Clientset
+	metadataClient metadata.Interface
+
+	mu        sync.Mutex
+	informers map[schema.GroupVersionResource]*resourceInformer
+	tracked   map[string]trackedObject
+	pending   map[string]bool
+
+	refreshCh chan struct{}
+}
+
+// resourceInformer is a running informer and the channel that stops it.
+type resourceInformer struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+}
+
+// trackedObject is an object the watcher has added to the stuck set.
+type trackedObject struct {
+	resource schema.GroupVersionResource
+	uid      types.UID
+}
+
+// NewWatcher creates a watcher using the given clientset for discovery and a metadata client built from restConfig.
+func NewWatcher(clientset *kubernetes.Clientset, restConfig *rest.Config) (*Watcher, error) {
+	metadataClient, err := metadata.NewForConfig(restConfig)
+	if err != nil {
+		logger.Errorf("Error creating metadata client: %v", err)
+		return nil, fmt.Errorf("error creating metadata client: %v", err)
+	}
+
+	return &Watcher{
+		clientset:      clientset,
+		metadataClient: metadataClient,
+		informers:      make(map[schema.GroupVersionResource]*resourceInformer),
+		tracked:        make(map[string]trackedObject),
+		pending:        make(map[string]bool),
+		refreshCh:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Run starts the informers and blocks until stopCh is closed.
+func (w *Watcher) Run(stopCh <-chan struct{}) error {
+	logger.Infoln("Starting watch mode...")
+
+	if err := k8s.VerifyAccessToCluster(w.clientset); err != nil {
+		return fmt.Errorf("error verifying access to cluster: %v", err)
+	}
+
+	if err := w.refresh(); err != nil {
+		return err
+	}
+
+	// Watch CRDs so informers are started and stopped as custom resources come and go.
+	crdInformer := metadatainformer.NewFilteredMetadataInformer(w.metadataClient, crdResource, metav1.NamespaceAll, 0, cache.Indexers{}, nil).Informer()
+	_, err := crdInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { w.requestRefresh() },
+		UpdateFunc: func(interface{}, interface{}) { w.requestRefresh() },
+		DeleteFunc: func(interface{}) { w.requestRefresh() },
+	})
+	if err != nil {
+		return fmt.Errorf("error adding CRD event handler: %v", err)
+	}
+	go crdInformer.Run(stopCh)
+
+	for {
+		select {
+		case <-stopCh:
+			w.stopAll()
+			return nil
+		case <-w.refreshCh:
+			if err := w.refresh(); err != nil {
+				logger.Errorf("Error refreshing informers: %v", err)
+			}
+		}
+	}
+}
+
+// requestRefresh queues a refresh of the informer set, coalescing bursts of CRD events.
+func (w *Watcher) requestRefresh() {
+	select {
+	case w.refreshCh <- struct{}{}:
+	default:
+	}
+}
+
+// refresh reconciles the running informers with the resources currently served by the cluster.
+// Informers of API groups whose discovery failed keep running until the group resolves again.
+func (w *Watcher) refresh() error {
+	logger.Debugln("Refreshing informers...")
+	resources, err := k8s.GetWatchableResources(w.clientset)
+	failedGroups := k8s.FailedGroups(err)
+	if err != nil && failedGroups == nil {
+		return fmt.Errorf("error fetching watchable resources: %v", err)
+	}
+	metrics.WriteDiscoveryFailures(failedGroups)
+
+	namespaces, err := k8s.GetNamespaces(w.clientset)
+	if err != nil {
+		logger.Errorf("Error fetching namespaces: %v", err)
+	} else {
+		metrics.WriteNamespaceCount(len(namespaces))
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	wanted := make(map[schema.GroupVersionResource]bool, len(resources))
+	for _, resource := range resources {
+		wanted[resource] = true
+		if _, ok := w.informers[resource]; ok {
+			continue
+		}
+		w.startInformer(resource)
+	}
+
+	for resource, running := range w.informers {
+		if wanted[resource] {
+			continue
+		}
+		if _, failed := failedGroups[resource.GroupVersion()]; failed {
+			// Keep watching a resource whose group could not be discovered rather than forgetting its objects.
+			continue
+		}
+		logger.Infof("Stopping informer for removed resource %s", resource)
+		close(running.stop)
+		delete(w.informers, resource)
+		w.untrackResource(resource)
+	}
+
+	metrics.WriteWatchedResourceCount(len(w.informers))
+	logger.Infof("Watching %d resources", len(w.informers))
+	return nil
+}
+
+// startInformer starts a metadata-only informer for a resource. The caller must hold w.mu.
+func (w *Watcher) startInformer(resource schema.GroupVersionResource) {
+	logger.Debugf("Starting informer for resource %s", resource)
+	informer := metadatainformer.NewFilteredMetadataInformer(w.metadataClient, resource, metav1.NamespaceAll, resyncPeriod, cache.Indexers{}, nil).Informer()
+	_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { w.handleObject(resource, obj) },
+		UpdateFunc: func(_, obj interface{}) { w.handleObject(resource, obj) },
+		DeleteFunc: func(obj interface{}) { w.handleDelete(resource, obj) },
+	})
+	if err != nil {
+		logger.Errorf("Error adding event handler for resource %s: %v", resource, err)
+		return
+	}
+
+	running := &resourceInformer{informer: informer, stop: make(chan struct{})}
+	w.informers[resource] = running
+	go informer.Run(running.stop)
+}
+
+// stopAll stops every running informer.
+func (w *Watcher) stopAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for resource, running := range w.informers {
+		close(running.stop)
+		delete(w.informers, resource)
+	}
+}
+
+// handleObject adds an object to the stuck set once it has been deleting for longer than the stuck threshold.
+// Objects still within the threshold are re-checked when it expires. An object recreated under the name of a
+// tracked object replaces it, so the series of the old UID are removed.
+func (w *Watcher) handleObject(resource schema.GroupVersionResource, obj interface{}) {
+	object, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		logger.Warnf("Unexpected object type %T for resource %s", obj, resource)
+		return
+	}
+
+	key := objectKey(resource, object.GetNamespace(), object.GetName())
+	now := time.Now()
+	isStuck := scan.IsStuck(resource, object, now)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tracked, isTracked := w.tracked[key]
+	if isTracked && tracked.uid != object.GetUID() {
+		logger.Infof("Object %s of resource %s in namespace %s has been recreated", object.GetName(), resource.Resource, object.GetNamespace())
+		w.untrack(key)
+		isTracked = false
+	}
+	if w.isSkipped(object) {
+		if isTracked {
+			logger.Infof("Object %s of resource %s in namespace %s is now annotated with %s", object.GetName(), resource.Resource, object.GetNamespace(), policy.AnnotationSkip)
+			metrics.SkipStuckObject(tracked.uid)
+			delete(w.tracked, key)
+		}
+		return
+	}
+	switch {
+	case isStuck && !isTracked:
+		logger.Infof("Object %s of resource %s in namespace %s is stuck deleting", object.GetName(), resource.Resource, object.GetNamespace())
+		metrics.AddStuckObject(objectScope(object), resource, object)
+		w.tracked[key] = trackedObject{resource: resource, uid: object.GetUID()}
+	case isStuck && isTracked:
+		// Refresh the recorded metadata, e.g. when a finalizer has been removed.
+		metrics.AddStuckObject(objectScope(object), resource, object)
+	case !isStuck && isTracked:
+		w.untrack(key)
+	case !isStuck && !w.pending[key]:
+		if stuckSince, isDeleting := scan.StuckSince(resource, object); isDeleting {
+			logger.Debugf("Object %s of resource %s in namespace %s is deleting, re-checking at %s", object.GetName(), resource.Resource, object.GetNamespace(), stuckSince)
+			w.pending[key] = true
+			storeKey, _ := cache.MetaNamespaceKeyFunc(object)
+			time.AfterFunc(stuckSince.Sub(now)+time.Second, func() { w.recheck(resource, key, storeKey) })
+		}
+	}
+}
+
+// isSkipped reports whether an object or its namespace is annotated to be skipped. The namespace is read
+// from the namespaces informer's cache. The caller must hold w.mu.
+func (w *Watcher) isSkipped(object metav1.Object) bool {
+	var namespaceAnnotations map[string]string
+	if running, ok := w.informers[namespacesResource]; ok && object.GetNamespace() != "" {
+		obj, exists, err := running.informer.GetStore().GetByKey(object.GetNamespace())
+		if namespace, ok := obj.(metav1.Object); ok && err == nil && exists {
+			namespaceAnnotations = namespace.GetAnnotations()
+		}
+	}
+	return policy.IsSkipped(object.GetAnnotations(), namespaceAnnotations)
+}
+
+// recheck re-evaluates an object from the informer cache once its stuck threshold has expired.
+func (w *Watcher) recheck(resource schema.GroupVersionResource, key, storeKey string) {
+	w.mu.Lock()
+	delete(w.pending, key)
+	running, ok := w.informers[resource]
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	obj, exists, err := running.informer.GetStore().GetByKey(storeKey)
+	if err != nil || !exists {
+		return
+	}
+	w.handleObject(resource, obj)
+}
+
+// handleDelete removes an object from the stuck set once it has disappeared from the cluster, unless the tracked
+// object is a newer one recreated under the same name.
+func (w *Watcher) handleDelete(resource schema.GroupVersionResource, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(*metav1.PartialObjectMetadata)
+	if !ok {
+		logger.Warnf("Unexpected object type %T for resource %s", obj, resource)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := objectKey(resource, object.GetNamespace(), object.GetName())
+	if tracked, isTracked := w.tracked[key]; isTracked && tracked.uid == object.GetUID() {
+		logger.Infof("Object %s of resource %s in namespace %s has been deleted", object.GetName(), resource.Resource, object.GetNamespace())
+		w.untrack(key)
+	}
+}
+
+// untrackResource removes every tracked object of a resource. The caller must hold w.mu.
+func (w *Watcher) untrackResource(resource schema.GroupVersionResource) {
+	for key, tracked := range w.tracked {
+		if tracked.resource == resource {
+			w.untrack(key)
+		}
+	}
+}
+
+// untrack removes a tracked object from the stuck set. The caller must hold w.mu.
+func (w *Watcher) untrack(key string) {
+	tracked := w.tracked[key]
+	metrics.RemoveStuckObject(tracked.uid)
+	delete(w.tracked, key)
+}
+
+// objectScope returns the scope of an object, which is cluster-scoped when it has no namespace.
+func objectScope(object metav1.Object) string {
+	if object.GetNamespace() == "" {
+		return metrics.ScopeCluster
+	}
+	return metrics.ScopeNamespaced
+}
+
+// objectKey builds the key used to track an object.
+func objectKey(resource schema.GroupVersionResource, namespace, name string) string {
+	return resource.String() + "/" + namespace + "/" + name
+}